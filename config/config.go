@@ -0,0 +1,402 @@
+package config
+
+import (
+  "database/sql"
+  "encoding/json"
+  "fmt"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/wangjohn/updike/philarios"
+  "github.com/wangjohn/updike/tfidf"
+)
+
+/*
+EnvPrefix is the prefix of the environment variables which override values in
+the configuration file.
+*/
+const EnvPrefix = "UPDIKE_"
+
+/*
+ListSeparator separates the values of list settings given in environment
+variables. It is a semicolon rather than a comma because categories and
+author names may contain commas.
+*/
+const ListSeparator = ";"
+
+/*
+Config is the configuration of the application, covering its database
+connections, the weighting of its TFIDF, the cache in front of its storage and
+the settings of its WordFactory.
+*/
+type Config struct {
+  Storage DatabaseConfig `json:"storage"`
+  TFIDF TFIDFConfig `json:"tfidf"`
+  Cache CacheConfig `json:"cache"`
+  Settings SettingsConfig `json:"settings"`
+}
+
+/*
+DatabaseConfig describes the connections to a database. When ShardDataSourceNames
+is non-empty, publications are partitioned across a database for each of its
+data sources instead of using DataSourceName. MaxOpenConns and MaxIdleConns
+bound the size of the connection pool of each database, where zero leaves the
+database/sql defaults in place, and connections are closed once they are older
+than ConnMaxLifetime unless it is zero.
+*/
+type DatabaseConfig struct {
+  DriverName string `json:"driver"`
+  DataSourceName string `json:"dsn"`
+  ShardDataSourceNames []string `json:"shard_dsns"`
+  MaxOpenConns int `json:"max_open_conns"`
+  MaxIdleConns int `json:"max_idle_conns"`
+  ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+}
+
+/*
+TFIDFConfig describes the connection to the TFIDF database, along with the
+scheme used to weigh words.
+*/
+type TFIDFConfig struct {
+  DatabaseConfig
+  Weighting WeightingConfig `json:"weighting"`
+}
+
+/*
+WeightingConfig chooses the scheme used to weigh words. A Scheme of "tfidf"
+multiplies the term frequency named by TF, which is one of "augmented", "raw",
+"log" or "boolean", by the inverse document frequency named by IDF, which is
+either "smoothed" or "probabilistic". A Scheme of "bm25" uses Okapi BM25 with
+the parameters K1 and B instead, and ignores TF and IDF.
+*/
+type WeightingConfig struct {
+  Scheme string `json:"scheme"`
+  TF string `json:"tf"`
+  IDF string `json:"idf"`
+  K1 float64 `json:"k1"`
+  B float64 `json:"b"`
+}
+
+var termFrequencies = map[string]func(counts tfidf.TermCounts) (float64){
+  "augmented": tfidf.AugmentedTF,
+  "raw": tfidf.RawTF,
+  "log": tfidf.LogTF,
+  "boolean": tfidf.BooleanTF,
+}
+
+var inverseDocumentFrequencies = map[string]func(documents, totalDocuments int) (float64){
+  "smoothed": tfidf.SmoothedIDF,
+  "probabilistic": tfidf.ProbabilisticIDF,
+}
+
+/*
+CacheConfig describes the cache of query results in front of storage. Size is
+the number of paragraphs that the cache holds, and a Size of zero disables the
+cache.
+*/
+type CacheConfig struct {
+  Size int `json:"size"`
+  TTL Duration `json:"ttl"`
+}
+
+/*
+SettingsConfig holds every field of philarios.Settings, under the names used
+in configuration files.
+*/
+type SettingsConfig struct {
+  WordsToCapture int `json:"words_to_capture"`
+  Categories []string `json:"categories"`
+  MatchAllCategories bool `json:"match_all_categories"`
+  MaxParagraphs int `json:"max_paragraphs"`
+  RankParagraphs bool `json:"rank_paragraphs"`
+  Language string `json:"language"`
+  Authors []string `json:"authors"`
+  StartDate string `json:"start_date"`
+  EndDate string `json:"end_date"`
+}
+
+/*
+Duration is a time.Duration which is written in configuration files as a
+string such as "90s" or "1h30m".
+*/
+type Duration struct {
+  time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) (error) {
+  var value string
+  err := json.Unmarshal(data, &value)
+  if err != nil {
+    return fmt.Errorf("durations must be strings such as \"90s\", got %s", data)
+  }
+
+  d.Duration, err = time.ParseDuration(value)
+  return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+  return json.Marshal(d.String())
+}
+
+/*
+Default returns the configuration used when neither a configuration file nor
+environment variables give a value.
+*/
+func Default() (Config) {
+  settings := philarios.DefaultSettingsObject()
+  return Config{
+    Storage: DatabaseConfig{
+      DriverName: "postgres",
+      DataSourceName: "host=localhost user=philarios dbname=philarios_storage sslmode=disable",
+    },
+    TFIDF: TFIDFConfig{
+      DatabaseConfig: DatabaseConfig{
+        DriverName: "postgres",
+        DataSourceName: "host=localhost user=philarios dbname=philarios_tfidf sslmode=disable",
+      },
+      Weighting: WeightingConfig{
+        Scheme: "tfidf",
+        TF: "augmented",
+        IDF: "smoothed",
+        K1: tfidf.DefaultBM25K1,
+        B: tfidf.DefaultBM25B,
+      },
+    },
+    Cache: CacheConfig{
+      Size: 100000,
+      TTL: Duration{10 * time.Minute},
+    },
+    Settings: SettingsConfig{
+      WordsToCapture: settings.WordsToCapture,
+      Categories: settings.Categories,
+      MatchAllCategories: settings.MatchAllCategories,
+      MaxParagraphs: settings.MaxParagraphs,
+      RankParagraphs: settings.RankParagraphs,
+      Language: settings.Language,
+      Authors: settings.Authors,
+      StartDate: settings.StartDate,
+      EndDate: settings.EndDate,
+    },
+  }
+}
+
+/*
+Load returns the configuration in the named JSON file, with values overridden
+by environment variables, and validates it. Values which are given by neither
+are taken from Default. An empty filename only reads environment variables.
+*/
+func Load(filename string) (Config, error) {
+  return load(filename, os.LookupEnv)
+}
+
+func load(filename string, lookupEnv func(name string) (string, bool)) (Config, error) {
+  config := Default()
+  if filename != "" {
+    err := config.readFile(filename)
+    if err != nil {
+      return config, err
+    }
+  }
+
+  err := config.readEnv(lookupEnv)
+  if err != nil {
+    return config, err
+  }
+
+  return config, config.Validate()
+}
+
+func (c *Config) readFile(filename string) (error) {
+  file, err := os.Open(filename)
+  if err != nil {
+    return fmt.Errorf("Could not open configuration file: %s", err.Error())
+  }
+  defer file.Close()
+
+  decoder := json.NewDecoder(file)
+  decoder.DisallowUnknownFields()
+  err = decoder.Decode(c)
+  if err != nil {
+    return fmt.Errorf("Could not read configuration file %s: %s", filename, err.Error())
+  }
+
+  return nil
+}
+
+/*
+envVar binds an environment variable, named without EnvPrefix, to the
+configuration value that it sets.
+*/
+type envVar struct {
+  Name string
+  Set func(c *Config, value string) (error)
+}
+
+var envVars = []envVar{
+  {"STORAGE_DRIVER", func(c *Config, v string) (error) { return setString(&c.Storage.DriverName, v) }},
+  {"STORAGE_DSN", func(c *Config, v string) (error) { return setString(&c.Storage.DataSourceName, v) }},
+  {"STORAGE_SHARD_DSNS", func(c *Config, v string) (error) { return setList(&c.Storage.ShardDataSourceNames, v) }},
+  {"STORAGE_MAX_OPEN_CONNS", func(c *Config, v string) (error) { return setInt(&c.Storage.MaxOpenConns, v) }},
+  {"STORAGE_MAX_IDLE_CONNS", func(c *Config, v string) (error) { return setInt(&c.Storage.MaxIdleConns, v) }},
+  {"STORAGE_CONN_MAX_LIFETIME", func(c *Config, v string) (error) { return setDuration(&c.Storage.ConnMaxLifetime, v) }},
+  {"TFIDF_DRIVER", func(c *Config, v string) (error) { return setString(&c.TFIDF.DriverName, v) }},
+  {"TFIDF_DSN", func(c *Config, v string) (error) { return setString(&c.TFIDF.DataSourceName, v) }},
+  {"TFIDF_MAX_OPEN_CONNS", func(c *Config, v string) (error) { return setInt(&c.TFIDF.MaxOpenConns, v) }},
+  {"TFIDF_MAX_IDLE_CONNS", func(c *Config, v string) (error) { return setInt(&c.TFIDF.MaxIdleConns, v) }},
+  {"TFIDF_CONN_MAX_LIFETIME", func(c *Config, v string) (error) { return setDuration(&c.TFIDF.ConnMaxLifetime, v) }},
+  {"TFIDF_WEIGHTING_SCHEME", func(c *Config, v string) (error) { return setString(&c.TFIDF.Weighting.Scheme, v) }},
+  {"TFIDF_WEIGHTING_TF", func(c *Config, v string) (error) { return setString(&c.TFIDF.Weighting.TF, v) }},
+  {"TFIDF_WEIGHTING_IDF", func(c *Config, v string) (error) { return setString(&c.TFIDF.Weighting.IDF, v) }},
+  {"TFIDF_WEIGHTING_K1", func(c *Config, v string) (error) { return setFloat(&c.TFIDF.Weighting.K1, v) }},
+  {"TFIDF_WEIGHTING_B", func(c *Config, v string) (error) { return setFloat(&c.TFIDF.Weighting.B, v) }},
+  {"CACHE_SIZE", func(c *Config, v string) (error) { return setInt(&c.Cache.Size, v) }},
+  {"CACHE_TTL", func(c *Config, v string) (error) { return setDuration(&c.Cache.TTL, v) }},
+  {"WORDS_TO_CAPTURE", func(c *Config, v string) (error) { return setInt(&c.Settings.WordsToCapture, v) }},
+  {"CATEGORIES", func(c *Config, v string) (error) { return setList(&c.Settings.Categories, v) }},
+  {"MATCH_ALL_CATEGORIES", func(c *Config, v string) (error) { return setBool(&c.Settings.MatchAllCategories, v) }},
+  {"MAX_PARAGRAPHS", func(c *Config, v string) (error) { return setInt(&c.Settings.MaxParagraphs, v) }},
+  {"RANK_PARAGRAPHS", func(c *Config, v string) (error) { return setBool(&c.Settings.RankParagraphs, v) }},
+  {"LANGUAGE", func(c *Config, v string) (error) { return setString(&c.Settings.Language, v) }},
+  {"AUTHORS", func(c *Config, v string) (error) { return setList(&c.Settings.Authors, v) }},
+  {"START_DATE", func(c *Config, v string) (error) { return setString(&c.Settings.StartDate, v) }},
+  {"END_DATE", func(c *Config, v string) (error) { return setString(&c.Settings.EndDate, v) }},
+}
+
+func (c *Config) readEnv(lookupEnv func(name string) (string, bool)) (error) {
+  for _, envVar := range envVars {
+    name := EnvPrefix + envVar.Name
+    value, exists := lookupEnv(name)
+    if !exists {
+      continue
+    }
+
+    err := envVar.Set(c, value)
+    if err != nil {
+      return fmt.Errorf("Invalid value %q for %s: %s", value, name, err.Error())
+    }
+  }
+
+  return nil
+}
+
+func setString(field *string, value string) (error) {
+  *field = value
+  return nil
+}
+
+func setInt(field *int, value string) (error) {
+  parsed, err := strconv.Atoi(value)
+  if err != nil {
+    return fmt.Errorf("expected an integer")
+  }
+  *field = parsed
+  return nil
+}
+
+func setFloat(field *float64, value string) (error) {
+  parsed, err := strconv.ParseFloat(value, 64)
+  if err != nil {
+    return fmt.Errorf("expected a number")
+  }
+  *field = parsed
+  return nil
+}
+
+func setBool(field *bool, value string) (error) {
+  parsed, err := strconv.ParseBool(value)
+  if err != nil {
+    return fmt.Errorf("expected true or false")
+  }
+  *field = parsed
+  return nil
+}
+
+func setDuration(field *Duration, value string) (error) {
+  parsed, err := time.ParseDuration(value)
+  if err != nil {
+    return fmt.Errorf("expected a duration such as \"90s\"")
+  }
+  field.Duration = parsed
+  return nil
+}
+
+/*
+setList splits a list on ListSeparator, ignoring empty values, so that an empty
+string gives an empty list.
+*/
+func setList(field *[]string, value string) (error) {
+  list := []string{}
+  for _, item := range strings.Split(value, ListSeparator) {
+    item = strings.TrimSpace(item)
+    if item != "" {
+      list = append(list, item)
+    }
+  }
+  *field = list
+  return nil
+}
+
+/*
+PhilariosSettings returns the settings of the WordFactory given by the
+configuration.
+*/
+func (c Config) PhilariosSettings() (philarios.Settings) {
+  return philarios.Settings{
+    WordsToCapture: c.Settings.WordsToCapture,
+    Categories: c.Settings.Categories,
+    MatchAllCategories: c.Settings.MatchAllCategories,
+    MaxParagraphs: c.Settings.MaxParagraphs,
+    RankParagraphs: c.Settings.RankParagraphs,
+    Language: c.Settings.Language,
+    Authors: c.Settings.Authors,
+    StartDate: c.Settings.StartDate,
+    EndDate: c.Settings.EndDate,
+  }
+}
+
+/*
+WeightingScheme returns the scheme chosen by the configuration, which must
+have been validated.
+*/
+func (w WeightingConfig) WeightingScheme() (tfidf.WeightingScheme) {
+  if w.Scheme == "bm25" {
+    return tfidf.BM25(w.K1, w.B)
+  }
+  return tfidf.WeightingScheme{TF: termFrequencies[w.TF], IDF: inverseDocumentFrequencies[w.IDF]}
+}
+
+/*
+Open opens a connection pool to the database with the given data source name,
+sized according to the configuration.
+*/
+func (d DatabaseConfig) Open(dataSourceName string) (*sql.DB, error) {
+  db, err := sql.Open(d.DriverName, dataSourceName)
+  if err != nil {
+    return nil, err
+  }
+
+  if d.MaxOpenConns > 0 {
+    db.SetMaxOpenConns(d.MaxOpenConns)
+  }
+  if d.MaxIdleConns > 0 {
+    db.SetMaxIdleConns(d.MaxIdleConns)
+  }
+  if d.ConnMaxLifetime.Duration > 0 {
+    db.SetConnMaxLifetime(d.ConnMaxLifetime.Duration)
+  }
+
+  return db, nil
+}
+
+/*
+DataSourceNames returns the data source name of each database described by the
+configuration, which is a single one unless it is sharded.
+*/
+func (d DatabaseConfig) DataSourceNames() ([]string) {
+  if len(d.ShardDataSourceNames) > 0 {
+    return d.ShardDataSourceNames
+  }
+  return []string{d.DataSourceName}
+}
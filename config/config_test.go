@@ -0,0 +1,179 @@
+package config
+
+import (
+  "io/ioutil"
+  "os"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/wangjohn/updike/tfidf"
+)
+
+func writeConfigFile(t *testing.T, contents string) (string) {
+  file, err := ioutil.TempFile("", "updike-config")
+  if err != nil {
+    t.Fatalf("Could not create a configuration file: %s", err.Error())
+  }
+  defer file.Close()
+
+  _, err = file.WriteString(contents)
+  if err != nil {
+    t.Fatalf("Could not write the configuration file: %s", err.Error())
+  }
+  return file.Name()
+}
+
+func fakeEnv(env map[string]string) (func(name string) (string, bool)) {
+  return func(name string) (string, bool) {
+    value, exists := env[name]
+    return value, exists
+  }
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+  err := Default().Validate()
+  if err != nil {
+    t.Errorf("The default configuration should be valid: %s", err.Error())
+  }
+}
+
+func TestLoadingConfig(t *testing.T) {
+  filename := writeConfigFile(t, `{
+    "storage": {
+      "shard_dsns": ["dbname=shard_a", "dbname=shard_b"],
+      "max_open_conns": 20,
+      "max_idle_conns": 5,
+      "conn_max_lifetime": "30m"
+    },
+    "tfidf": {"weighting": {"scheme": "bm25", "b": 0.5}},
+    "cache": {"ttl": "1m"},
+    "settings": {"words_to_capture": 3, "categories": ["classic"], "language": "french"}
+  }`)
+  defer os.Remove(filename)
+
+  config, err := load(filename, fakeEnv(map[string]string{
+    "UPDIKE_TFIDF_DSN": "dbname=tfidf",
+    "UPDIKE_LANGUAGE": "german",
+    "UPDIKE_AUTHORS": "Dickens, Charles; Austen, Jane",
+    "UPDIKE_RANK_PARAGRAPHS": "false",
+    "UPDIKE_START_DATE": "1800-01-01",
+  }))
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when loading the configuration: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Name string
+    Value interface{}
+    ExpectedValue interface{}
+  }{
+    {"storage shards", config.Storage.DataSourceNames(), []string{"dbname=shard_a", "dbname=shard_b"}},
+    {"storage max_open_conns", config.Storage.MaxOpenConns, 20},
+    {"storage conn_max_lifetime", config.Storage.ConnMaxLifetime.Duration, 30 * time.Minute},
+    {"storage driver", config.Storage.DriverName, "postgres"},
+    {"tfidf dsn", config.TFIDF.DataSourceNames(), []string{"dbname=tfidf"}},
+    {"tfidf weighting", config.TFIDF.Weighting, WeightingConfig{"bm25", "augmented", "smoothed", 1.2, 0.5}},
+    {"cache size", config.Cache.Size, Default().Cache.Size},
+    {"cache ttl", config.Cache.TTL.Duration, time.Minute},
+    {"words to capture", config.Settings.WordsToCapture, 3},
+    {"categories", config.Settings.Categories, []string{"classic"}},
+    {"language", config.Settings.Language, "german"},
+    {"authors", config.Settings.Authors, []string{"Dickens, Charles", "Austen, Jane"}},
+    {"rank paragraphs", config.Settings.RankParagraphs, false},
+    {"max paragraphs", config.PhilariosSettings().MaxParagraphs, Default().Settings.MaxParagraphs},
+    {"start date", config.PhilariosSettings().StartDate, "1800-01-01"},
+  }
+
+  for _, fixture := range fixtures {
+    if !reflect.DeepEqual(fixture.Value, fixture.ExpectedValue) {
+      t.Errorf("Should have loaded %v for %s, instead loaded %v", fixture.ExpectedValue, fixture.Name, fixture.Value)
+    }
+  }
+}
+
+func TestLoadingInvalidConfig(t *testing.T) {
+  fixtures := []struct {
+    Contents string
+    Env map[string]string
+    ExpectedProblems []string
+  }{
+    {`{"storage": {"dsn": ""}}`, nil, []string{"storage.dsn or storage.shard_dsns must be set"}},
+    {`{"storage": {"shard_dsns": ["a", "", "a"]}}`, nil, []string{
+      "storage.shard_dsns[1] must not be empty",
+      "storage.shard_dsns[2] is the same database as an earlier shard",
+    }},
+    {`{"tfidf": {"shard_dsns": ["a"], "max_open_conns": 2, "max_idle_conns": 4}}`, nil, []string{
+      "tfidf cannot be sharded",
+      "tfidf.max_idle_conns (4) must not exceed tfidf.max_open_conns (2)",
+    }},
+    {`{"settings": {"words_to_capture": 0, "max_paragraphs": -1}}`, nil, []string{
+      "settings.words_to_capture must be positive, got 0",
+      "settings.max_paragraphs must not be negative, got -1",
+    }},
+    {`{}`, map[string]string{"UPDIKE_START_DATE": "1900-01-01", "UPDIKE_END_DATE": "1800-01-01"}, []string{
+      "settings.start_date 1900-01-01 must not be after settings.end_date 1800-01-01",
+    }},
+    {`{}`, map[string]string{"UPDIKE_END_DATE": "January 1900"}, []string{
+      `settings.end_date must be a date in the form YYYY-MM-DD, got "January 1900"`,
+    }},
+    {`{}`, map[string]string{"UPDIKE_CACHE_SIZE": "lots"}, []string{
+      `Invalid value "lots" for UPDIKE_CACHE_SIZE: expected an integer`,
+    }},
+    {`{"cache": {"ttl": 60}}`, nil, []string{"durations must be strings"}},
+    {`{"tfidf": {"weighting": {"tf": "double", "idf": ""}}}`, nil, []string{
+      `tfidf.weighting.tf must be augmented, raw, log or boolean, got "double"`,
+      `tfidf.weighting.idf must be smoothed or probabilistic, got ""`,
+    }},
+    {`{"tfidf": {"weighting": {"scheme": "bm25", "k1": -1, "b": 2}}}`, nil, []string{
+      "tfidf.weighting.k1 must not be negative, got -1",
+      "tfidf.weighting.b must be between 0 and 1, got 2",
+    }},
+    {`{}`, map[string]string{"UPDIKE_TFIDF_WEIGHTING_SCHEME": "cosine"}, []string{
+      `tfidf.weighting.scheme must be tfidf or bm25, got "cosine"`,
+    }},
+    {`{"settings": {"word_to_capture": 3}}`, nil, []string{`unknown field "word_to_capture"`}},
+  }
+
+  for _, fixture := range fixtures {
+    filename := writeConfigFile(t, fixture.Contents)
+    _, err := load(filename, fakeEnv(fixture.Env))
+    os.Remove(filename)
+
+    if err == nil {
+      t.Errorf("Should have thrown an error when loading %s with %v", fixture.Contents, fixture.Env)
+      continue
+    }
+
+    for _, problem := range fixture.ExpectedProblems {
+      if !strings.Contains(err.Error(), problem) {
+        t.Errorf("Error loading %s with %v should have mentioned %q, instead was: %s",
+          fixture.Contents, fixture.Env, problem, err.Error())
+      }
+    }
+  }
+}
+
+func TestWeightingSchemeFromConfig(t *testing.T) {
+  counts := tfidf.TermCounts{Freq: 2, DocMaxWordFreq: 4, DocLength: 10, AvgDocLength: 10}
+  fixtures := []struct {
+    Weighting WeightingConfig
+    ExpectedScheme tfidf.WeightingScheme
+  }{
+    {Default().TFIDF.Weighting, tfidf.DefaultWeightingScheme()},
+    {WeightingConfig{Scheme: "tfidf", TF: "log", IDF: "probabilistic"},
+      tfidf.WeightingScheme{TF: tfidf.LogTF, IDF: tfidf.ProbabilisticIDF}},
+    {WeightingConfig{Scheme: "bm25", K1: 2, B: 0.5}, tfidf.BM25(2, 0.5)},
+  }
+
+  for _, fixture := range fixtures {
+    scheme := fixture.Weighting.WeightingScheme()
+    expected := fixture.ExpectedScheme
+    if scheme.TF(counts) != expected.TF(counts) || scheme.IDF(3, 10) != expected.IDF(3, 10) ||
+      scheme.DocumentLengths != expected.DocumentLengths {
+      t.Errorf("Should have weighed words the same way as %+v for %+v, instead obtained %+v",
+        expected, fixture.Weighting, scheme)
+    }
+  }
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+  "fmt"
+  "strings"
+  "time"
+)
+
+/*
+ValidationError lists every problem found with a configuration, so that they
+can all be fixed at once.
+*/
+type ValidationError struct {
+  Problems []string
+}
+
+func (v ValidationError) Error() (string) {
+  return "Invalid configuration:\n  " + strings.Join(v.Problems, "\n  ")
+}
+
+/*
+validator collects the problems found while validating a configuration.
+*/
+type validator struct {
+  problems []string
+}
+
+func (v *validator) check(ok bool, format string, args ...interface{}) {
+  if !ok {
+    v.problems = append(v.problems, fmt.Sprintf(format, args...))
+  }
+}
+
+/*
+Validate checks that the values of the configuration make sense, and returns a
+ValidationError describing each of the values which don't.
+*/
+func (c Config) Validate() (error) {
+  var v validator
+  c.Storage.validate(&v, "storage", true)
+  c.TFIDF.validate(&v, "tfidf", false)
+  c.TFIDF.Weighting.validate(&v)
+
+  v.check(c.Cache.Size >= 0, "cache.size must not be negative, got %d", c.Cache.Size)
+  v.check(c.Cache.TTL.Duration >= 0, "cache.ttl must not be negative, got %s", c.Cache.TTL)
+
+  s := c.Settings
+  v.check(s.WordsToCapture > 0, "settings.words_to_capture must be positive, got %d", s.WordsToCapture)
+  v.check(s.MaxParagraphs >= 0, "settings.max_paragraphs must not be negative, got %d", s.MaxParagraphs)
+  v.check(s.Language != "", "settings.language must be set")
+  startValid := validateDate(&v, "settings.start_date", s.StartDate)
+  endValid := validateDate(&v, "settings.end_date", s.EndDate)
+  if startValid && endValid && s.StartDate != "" && s.EndDate != "" {
+    v.check(s.StartDate <= s.EndDate, "settings.start_date %s must not be after settings.end_date %s",
+      s.StartDate, s.EndDate)
+  }
+
+  if len(v.problems) > 0 {
+    return ValidationError{v.problems}
+  }
+  return nil
+}
+
+func (d DatabaseConfig) validate(v *validator, name string, shardable bool) {
+  v.check(d.DriverName != "", "%s.driver must be set", name)
+  if shardable {
+    v.check(d.DataSourceName != "" || len(d.ShardDataSourceNames) > 0,
+      "%s.dsn or %s.shard_dsns must be set", name, name)
+  } else {
+    v.check(d.DataSourceName != "", "%s.dsn must be set", name)
+    v.check(len(d.ShardDataSourceNames) == 0, "%s cannot be sharded, so %s.shard_dsns must not be set", name, name)
+  }
+
+  seen := make(map[string]bool)
+  for i, dataSourceName := range d.ShardDataSourceNames {
+    v.check(dataSourceName != "", "%s.shard_dsns[%d] must not be empty", name, i)
+    v.check(!seen[dataSourceName], "%s.shard_dsns[%d] is the same database as an earlier shard", name, i)
+    seen[dataSourceName] = true
+  }
+
+  v.check(d.MaxOpenConns >= 0, "%s.max_open_conns must not be negative, got %d", name, d.MaxOpenConns)
+  v.check(d.MaxIdleConns >= 0, "%s.max_idle_conns must not be negative, got %d", name, d.MaxIdleConns)
+  if d.MaxOpenConns > 0 {
+    v.check(d.MaxIdleConns <= d.MaxOpenConns, "%s.max_idle_conns (%d) must not exceed %s.max_open_conns (%d)",
+      name, d.MaxIdleConns, name, d.MaxOpenConns)
+  }
+  v.check(d.ConnMaxLifetime.Duration >= 0, "%s.conn_max_lifetime must not be negative, got %s",
+    name, d.ConnMaxLifetime)
+}
+
+func (w WeightingConfig) validate(v *validator) {
+  switch w.Scheme {
+  case "tfidf":
+    _, knownTF := termFrequencies[w.TF]
+    v.check(knownTF, "tfidf.weighting.tf must be augmented, raw, log or boolean, got %q", w.TF)
+    _, knownIDF := inverseDocumentFrequencies[w.IDF]
+    v.check(knownIDF, "tfidf.weighting.idf must be smoothed or probabilistic, got %q", w.IDF)
+  case "bm25":
+    v.check(w.K1 >= 0, "tfidf.weighting.k1 must not be negative, got %g", w.K1)
+    v.check(w.B >= 0 && w.B <= 1, "tfidf.weighting.b must be between 0 and 1, got %g", w.B)
+  default:
+    v.check(false, "tfidf.weighting.scheme must be tfidf or bm25, got %q", w.Scheme)
+  }
+}
+
+/*
+validateDate checks that the date is empty or in the form YYYY-MM-DD, and
+returns whether it is.
+*/
+func validateDate(v *validator, name, date string) (bool) {
+  if date == "" {
+    return true
+  }
+
+  _, err := time.Parse("2006-01-02", date)
+  v.check(err == nil, "%s must be a date in the form YYYY-MM-DD, got %q", name, date)
+  return err == nil
+}
@@ -99,6 +99,7 @@ func (d DataIngestor) ingestWikipediaPage(page wikipediaPage) (error) {
     SourceURL: page.SourceURL(),
     Encoding: "utf-8",
     Type: "wikipedia_article",
+    Language: "english",
     Categories: []string{},
     Text: body,
   }
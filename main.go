@@ -1,41 +1,287 @@
 package main
 
 import (
-  "database/sql"
+  "bufio"
+  "flag"
+  "fmt"
+  "io"
+  "log"
+  "os"
+  "text/tabwriter"
+
+  "github.com/wangjohn/updike/config"
   "github.com/wangjohn/updike/philarios"
   "github.com/wangjohn/updike/tfidf"
   "github.com/wangjohn/updike/dataingestor"
 )
 
 const (
-  tfidfDriverName = "postgres"
-  tfidfDataSourceName = "host=localhost user=philarios dbname=philarios_tfidf sslmode=disable"
+  backfillBatchSize = 10000
 
-  storageDriverName = "postgres"
-  storageDataSourceName = "host=localhost user=philarios dbname=philarios_storage sslmode=disable"
+  defaultWikipediaDump = "/home/wangjohn/wikipedia/enwiki-latest-pages-articles.xml"
 )
 
+const usage = `Usage: updike [-config file] [command] [arguments]
+
+The configuration file is JSON, and its values may be overridden by
+environment variables prefixed with ` + config.EnvPrefix + `, such as ` + config.EnvPrefix + `STORAGE_DSN.
+
+Commands:
+  ingest [dump]  ingest a Wikipedia XML dump (the default command)
+  stats          print the size of the corpus in storage
+  export [file]  write the corpus in storage to a file, or to standard output
+  import [file]  add the corpus in a file, or standard input, to storage
+  backfill       fill in data missing from paragraphs stored by older versions
+`
+
 func main() {
-  wordFactory, _ := createWordFactory()
+  configFile := flag.String("config", "", "the configuration file to read")
+  flag.Usage = func() {
+    fmt.Fprint(os.Stderr, usage)
+  }
+  flag.Parse()
+
+  command := "ingest"
+  if flag.NArg() > 0 {
+    command = flag.Arg(0)
+  }
+  argument := flag.Arg(1)
+
+  switch command {
+  case "ingest", "stats", "export", "import", "backfill":
+  default:
+    flag.Usage()
+    os.Exit(2)
+  }
+
+  cfg, err := config.Load(*configFile)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  if command == "backfill" {
+    err = backfillStorage(cfg.Storage)
+    if err != nil {
+      log.Fatal(err)
+    }
+    return
+  }
+
+  wordFactory, err := createWordFactory(cfg)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  switch command {
+  case "ingest":
+    dump := defaultWikipediaDump
+    if argument != "" {
+      dump = argument
+    }
 
-  ingestor := dataingestor.DataIngestor{wordFactory.Storage}
-  ingestor.IngestWikipedia("/home/wangjohn/wikipedia/enwiki-latest-pages-articles.xml")
+    ingestor := dataingestor.DataIngestor{Storage: wordFactory.Storage}
+    err = ingestor.IngestWikipedia(dump)
+  case "stats":
+    var stats philarios.CorpusStats
+    stats, err = wordFactory.Storage.CorpusStats()
+    if err == nil {
+      err = printCorpusStats(os.Stdout, stats)
+    }
+  case "export":
+    err = exportCorpus(wordFactory.Storage, argument)
+  case "import":
+    err = importCorpus(wordFactory.Storage, argument)
+  }
+
+  if err != nil {
+    log.Fatal(err)
+  }
+}
+
+/*
+printCorpusStats writes the corpus stats as a table, with a section for each
+breakdown of the corpus.
+*/
+func printCorpusStats(out io.Writer, stats philarios.CorpusStats) (error) {
+  w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+  fmt.Fprintf(w, "Dates:\t%s to %s\n\n", stats.EarliestDate, stats.LatestDate)
+
+  breakdowns := []struct {
+    Name string
+    Counts map[string]philarios.CorpusCounts
+  }{
+    {"Type", stats.ByType},
+    {"Author", stats.ByAuthor},
+    {"Category", stats.ByCategory},
+  }
+
+  for _, breakdown := range breakdowns {
+    fmt.Fprintf(w, "%s\tPublications\tParagraphs\tTokens\n", breakdown.Name)
+    for _, key := range philarios.SortedKeys(breakdown.Counts) {
+      counts := breakdown.Counts[key]
+      fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", key, counts.Publications, counts.Paragraphs, counts.Tokens)
+    }
+    fmt.Fprint(w, "\n")
+  }
+
+  fmt.Fprintf(w, "Total\t%d\t%d\t%d\n",
+    stats.Publications, stats.Paragraphs, stats.Tokens)
+  fmt.Fprintf(w, "Duplicate paragraphs\t\t%d\t\n", stats.DuplicateParagraphs)
+  return w.Flush()
 }
 
-func createWordFactory() (*philarios.WordFactory, error) {
-  storageDb, err := sql.Open(storageDriverName, storageDataSourceName)
+func createWordFactory(cfg config.Config) (*philarios.WordFactory, error) {
+  storage, err := createStorage(cfg.Storage)
   if err != nil {
     return nil, err
   }
+  if cfg.Cache.Size > 0 {
+    storage = philarios.NewCachedStorage(storage, cfg.Cache.Size, cfg.Cache.TTL.Duration)
+  }
 
-  storage := philarios.PostgresStorage{storageDb}
-  settings := philarios.DefaultSettingsObject()
-  tfidfDb, err := sql.Open(tfidfDriverName, tfidfDataSourceName)
+  tfidfDb, err := cfg.TFIDF.Open(cfg.TFIDF.DataSourceName)
   if err != nil {
     return nil, err
   }
 
-  tfidf := tfidf.PersistentTFIDF{tfidfDb}
-  wordFactory := philarios.WordFactory{storage, settings, tfidf}
+  tfidf := tfidf.NewPersistentTFIDF(tfidfDb)
+  tfidf.Weighting = cfg.TFIDF.Weighting.WeightingScheme()
+  err = tfidf.Migrate()
+  if err != nil {
+    return nil, err
+  }
+
+  wordFactory := philarios.WordFactory{Storage: storage, Settings: cfg.PhilariosSettings(), TFIDF: tfidf}
   return &wordFactory, nil
 }
+
+/*
+createStorage opens and migrates the storage database, or each of its shards
+if it is sharded.
+*/
+func createStorage(cfg config.DatabaseConfig) (philarios.Storage, error) {
+  databases, err := openStorageDatabases(cfg)
+  if err != nil {
+    return nil, err
+  }
+
+  if len(databases) == 1 {
+    return databases[0], nil
+  }
+
+  shards := make([]philarios.Storage, len(databases))
+  for i, database := range databases {
+    shards[i] = database
+  }
+  storage, err := philarios.NewShardedStorage(shards...)
+  if err != nil {
+    return nil, err
+  }
+  return storage, nil
+}
+
+/*
+openStorageDatabases opens and migrates the storage database, or each of its
+shards if it is sharded.
+*/
+func openStorageDatabases(cfg config.DatabaseConfig) ([]philarios.PostgresStorage, error) {
+  var databases []philarios.PostgresStorage
+  for _, dataSourceName := range cfg.DataSourceNames() {
+    storageDb, err := cfg.Open(dataSourceName)
+    if err != nil {
+      return nil, err
+    }
+
+    storage := philarios.PostgresStorage{SQLDatabase: storageDb}
+    err = storage.Migrate()
+    if err != nil {
+      return nil, err
+    }
+
+    databases = append(databases, storage)
+  }
+
+  return databases, nil
+}
+
+/*
+backfillStorage fills in the search vectors, positions, token counts and
+content hashes of paragraphs which were stored in the storage database, or in
+each of its shards, before they existed.
+*/
+func backfillStorage(cfg config.DatabaseConfig) (error) {
+  databases, err := openStorageDatabases(cfg)
+  if err != nil {
+    return err
+  }
+
+  for _, database := range databases {
+    backfills := []struct {
+      Name string
+      Backfill func(batchSize int) (int, error)
+    }{
+      {"search vectors", database.BackfillSearchVectors},
+      {"positions", database.BackfillPositions},
+      {"token counts", database.BackfillTokenCounts},
+      {"content hashes", database.BackfillContentHashes},
+    }
+
+    for _, backfill := range backfills {
+      updated, err := backfill.Backfill(backfillBatchSize)
+      if err != nil {
+        return err
+      }
+      log.Printf("Backfilled the %s of %d paragraphs", backfill.Name, updated)
+    }
+  }
+
+  return nil
+}
+
+/*
+exportCorpus writes the corpus in storage to the named file, or to standard
+output if no file is named.
+*/
+func exportCorpus(storage philarios.Storage, filename string) (error) {
+  file := os.Stdout
+  if filename != "" {
+    var err error
+    file, err = os.Create(filename)
+    if err != nil {
+      return err
+    }
+    defer file.Close()
+  }
+
+  out := bufio.NewWriter(file)
+  exported, err := philarios.ExportCorpus(storage, out)
+  if err != nil {
+    return err
+  }
+  if err = out.Flush(); err != nil {
+    return err
+  }
+
+  log.Printf("Exported %d publications", exported)
+  return nil
+}
+
+/*
+importCorpus adds the corpus in the named file, or in standard input if no
+file is named, to storage.
+*/
+func importCorpus(storage philarios.Storage, filename string) (error) {
+  in := os.Stdin
+  if filename != "" {
+    file, err := os.Open(filename)
+    if err != nil {
+      return err
+    }
+    defer file.Close()
+    in = file
+  }
+
+  imported, err := philarios.ImportCorpus(storage, bufio.NewReader(in))
+  log.Printf("Imported %d publications", imported)
+  return err
+}
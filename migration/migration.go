@@ -0,0 +1,181 @@
+package migration
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "sort"
+)
+
+/*
+Migration is a single, ordered change to a database schema. Migrations are
+applied in increasing order of Version, and each one is applied at most once.
+*/
+type Migration struct {
+  Version int
+  Description string
+  Up string
+}
+
+var schemaVersionSchema = `
+CREATE TABLE IF NOT EXISTS schema_version (
+  component text,
+  version integer,
+  description text,
+  applied_at timestamp with time zone DEFAULT now(),
+  PRIMARY KEY (component, version)
+);
+`
+
+/*
+Migrate applies the migrations for a component which have not yet been applied
+to the database. The versions which have been applied are recorded in the
+schema_version table, keyed by component so that several components may share
+a database. Each migration runs in its own transaction, so a failing migration
+leaves the database at the last version which succeeded.
+*/
+func Migrate(db *sql.DB, component string, migrations []Migration) (error) {
+  return MigrateContext(context.Background(), db, component, migrations)
+}
+
+/*
+MigrateContext applies migrations in the same way as Migrate, and stops
+before the next migration once ctx is cancelled. A migration which is running
+when ctx is cancelled is rolled back.
+*/
+func MigrateContext(ctx context.Context, db *sql.DB, component string, migrations []Migration) (error) {
+  err := Validate(migrations)
+  if err != nil {
+    return err
+  }
+
+  _, err = db.ExecContext(ctx, schemaVersionSchema)
+  if err != nil {
+    return err
+  }
+
+  current, err := CurrentVersionContext(ctx, db, component)
+  if err != nil {
+    return err
+  }
+
+  for _, migration := range sortedMigrations(migrations) {
+    if migration.Version <= current {
+      continue
+    }
+
+    err = apply(ctx, db, component, migration)
+    if err != nil {
+      return fmt.Errorf("Migration %d (%s) of %s failed: %v",
+        migration.Version, migration.Description, component, err)
+    }
+  }
+
+  return nil
+}
+
+/*
+CurrentVersion returns the latest version of the component's migrations which
+has been applied to the database, or 0 if none have been applied.
+*/
+func CurrentVersion(db *sql.DB, component string) (int, error) {
+  return CurrentVersionContext(context.Background(), db, component)
+}
+
+/*
+CurrentVersionContext returns the current version in the same way as
+CurrentVersion, using ctx for the query.
+*/
+func CurrentVersionContext(ctx context.Context, db *sql.DB, component string) (int, error) {
+  var version sql.NullInt64
+  err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_version
+    WHERE component=$1`, component).Scan(&version)
+  if err != nil {
+    return 0, err
+  }
+
+  return int(version.Int64), nil
+}
+
+func apply(ctx context.Context, db *sql.DB, component string, migration Migration) (error) {
+  txn, err := db.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+
+  // Another process may be migrating the same database, so we hold a lock
+  // while checking whether the migration has already been applied.
+  _, err = txn.ExecContext(ctx, `LOCK TABLE schema_version IN SHARE ROW EXCLUSIVE MODE`)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  var applied bool
+  err = txn.QueryRowContext(ctx, `SELECT EXISTS(
+      SELECT 1 FROM schema_version WHERE component=$1 AND version=$2)`,
+    component, migration.Version).Scan(&applied)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+  if applied {
+    return txn.Rollback()
+  }
+
+  _, err = txn.ExecContext(ctx, migration.Up)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  _, err = txn.ExecContext(ctx, `INSERT INTO schema_version (component, version, description)
+    VALUES ($1, $2, $3)`, component, migration.Version, migration.Description)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  return txn.Commit()
+}
+
+/*
+Validate checks that every migration has a positive version and that no two
+migrations share a version.
+*/
+func Validate(migrations []Migration) (error) {
+  seen := make(map[int]bool)
+  for _, migration := range migrations {
+    if migration.Version <= 0 {
+      return fmt.Errorf("Migration '%s' has invalid version %d, versions must be positive",
+        migration.Description, migration.Version)
+    }
+    if seen[migration.Version] {
+      return fmt.Errorf("Migration version %d is used more than once", migration.Version)
+    }
+    seen[migration.Version] = true
+  }
+
+  return nil
+}
+
+type byVersion []Migration
+
+func (m byVersion) Len() int {
+  return len(m)
+}
+
+func (m byVersion) Less(i, j int) bool {
+  return m[i].Version < m[j].Version
+}
+
+func (m byVersion) Swap(i, j int) {
+  m[i], m[j] = m[j], m[i]
+}
+
+func sortedMigrations(migrations []Migration) ([]Migration) {
+  sorted := make([]Migration, len(migrations))
+  copy(sorted, migrations)
+  sort.Sort(byVersion(sorted))
+  return sorted
+}
@@ -0,0 +1,43 @@
+package migration
+
+import (
+  "testing"
+)
+
+func TestValidate(t *testing.T) {
+  fixtures := []struct {
+    Migrations []Migration
+    ExpectError bool
+  }{
+    {[]Migration{}, false},
+    {[]Migration{{1, "first", ""}, {2, "second", ""}}, false},
+    {[]Migration{{3, "third", ""}, {1, "first", ""}}, false},
+    {[]Migration{{1, "first", ""}, {1, "again", ""}}, true},
+    {[]Migration{{0, "zero", ""}}, true},
+    {[]Migration{{-2, "negative", ""}}, true},
+  }
+
+  for _, fixture := range fixtures {
+    err := Validate(fixture.Migrations)
+    if fixture.ExpectError && err == nil {
+      t.Errorf("Expected an error validating migrations %v", fixture.Migrations)
+    } else if !fixture.ExpectError && err != nil {
+      t.Errorf("Did not expect an error validating migrations %v: err=%v", fixture.Migrations, err)
+    }
+  }
+}
+
+func TestSortedMigrations(t *testing.T) {
+  migrations := []Migration{{3, "third", ""}, {1, "first", ""}, {2, "second", ""}}
+  sorted := sortedMigrations(migrations)
+
+  for i, migration := range sorted {
+    if migration.Version != i + 1 {
+      t.Errorf("Migrations were not sorted by version, obtained %v", sorted)
+    }
+  }
+
+  if migrations[0].Version != 3 {
+    t.Errorf("Sorting should not have modified the original migrations, obtained %v", migrations)
+  }
+}
@@ -0,0 +1,35 @@
+package pgcopy
+
+import (
+  "context"
+  "database/sql"
+)
+
+/*
+CopyRows bulk loads the rows within the transaction, using a COPY statement
+created by pq.CopyIn. Each row holds a value for every column named in the
+statement, in the same order.
+*/
+func CopyRows(ctx context.Context, txn *sql.Tx, copyStatement string, rows [][]interface{}) (error) {
+  stmt, err := txn.PrepareContext(ctx, copyStatement)
+  if err != nil {
+    return err
+  }
+
+  for _, row := range rows {
+    _, err = stmt.ExecContext(ctx, row...)
+    if err != nil {
+      stmt.Close()
+      return err
+    }
+  }
+
+  // An Exec without arguments flushes the buffered rows.
+  _, err = stmt.ExecContext(ctx)
+  if err != nil {
+    stmt.Close()
+    return err
+  }
+
+  return stmt.Close()
+}
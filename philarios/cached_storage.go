@@ -0,0 +1,343 @@
+package philarios
+
+import (
+  "container/list"
+  "context"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+)
+
+/*
+CachedStorage is a Storage which caches the results of word and phrase queries
+made to an underlying Storage. The cache holds at most Size paragraphs,
+evicting the results of the least recently used queries first, and results
+older than the TTL are queried again. Each query counts as at least one
+paragraph, and the results of a query with more than Size paragraphs aren't
+cached, so that they're streamed to the handler rather than being held in
+memory. Any call which changes the publications in storage
+empties the cache. Other calls are passed straight through to the underlying
+Storage. A CachedStorage should be created with NewCachedStorage, and copies of
+it share the same cache.
+*/
+type CachedStorage struct {
+  Storage Storage
+  cache *queryCache
+  ctx context.Context
+}
+
+/*
+CacheStats counts the queries which were answered from the cache and those
+which had to be passed to the underlying Storage.
+*/
+type CacheStats struct {
+  Hits int
+  Misses int
+}
+
+/*
+NewCachedStorage returns a CachedStorage in front of storage, which caches at
+most size paragraphs of query results for ttl each. A ttl of zero keeps results
+until they are evicted or invalidated.
+*/
+func NewCachedStorage(storage Storage, size int, ttl time.Duration) (CachedStorage) {
+  return CachedStorage{Storage: storage, cache: &queryCache{
+    size: size,
+    ttl: ttl,
+    entries: make(map[string]*list.Element),
+    recency: list.New(),
+    now: time.Now,
+  }}
+}
+
+/*
+queryCache is a least recently used cache of query results, which holds at
+most size paragraphs. Each invalidation increments its generation, so that
+results of queries which were running during an invalidation aren't cached.
+*/
+type queryCache struct {
+  sync.Mutex
+  size int
+  paragraphs int
+  ttl time.Duration
+  entries map[string]*list.Element
+  recency *list.List
+  generation int
+  stats CacheStats
+  now func() (time.Time)
+}
+
+type cacheEntry struct {
+  key string
+  paragraphs []Paragraph
+  expires time.Time
+}
+
+/*
+WithContext returns a copy of the storage whose underlying Storage is bound to
+ctx, and which doesn't answer queries from the cache once ctx is done. The copy
+shares the same cache.
+*/
+func (c CachedStorage) WithContext(ctx context.Context) (Storage) {
+  return CachedStorage{Storage: c.Storage.WithContext(ctx), cache: c.cache, ctx: ctx}
+}
+
+/*
+Context returns the context that the storage is bound to, which is
+context.Background() unless one was given to WithContext.
+*/
+func (c CachedStorage) Context() (context.Context) {
+  if c.ctx == nil {
+    return context.Background()
+  }
+  return c.ctx
+}
+
+/*
+CacheStats returns the number of cache hits and misses so far.
+*/
+func (c CachedStorage) CacheStats() (CacheStats) {
+  c.cache.Lock()
+  defer c.cache.Unlock()
+  return c.cache.stats
+}
+
+/*
+Invalidate empties the cache. It only needs to be called when publications are
+changed without going through the CachedStorage.
+*/
+func (c CachedStorage) Invalidate() {
+  c.cache.Lock()
+  defer c.cache.Unlock()
+
+  c.cache.generation++
+  c.cache.paragraphs = 0
+  c.cache.entries = make(map[string]*list.Element)
+  c.cache.recency.Init()
+}
+
+/*
+QueryForWord returns the paragraphs containing the query word, from the cache
+if they are cached.
+*/
+func (c CachedStorage) QueryForWord(word string, categories []string) ([]Paragraph, error) {
+  return c.QueryForWordWithOptions(word, QueryOptions{Categories: categories})
+}
+
+func (c CachedStorage) QueryForWordWithOptions(word string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return c.EachParagraphForWord(word, options, handler)
+  })
+}
+
+/*
+EachParagraphForWord calls the handler with each paragraph containing the
+query word in the same way as the underlying Storage. On a cache miss, the
+results are only cached if the handler went through all of them.
+*/
+func (c CachedStorage) EachParagraphForWord(word string, options QueryOptions, handler ParagraphHandler) (error) {
+  return c.eachParagraph(queryCacheKey("word", word, options), handler,
+    func(handler ParagraphHandler) (error) {
+      return c.Storage.EachParagraphForWord(word, options, handler)
+    })
+}
+
+/*
+QueryForPhrase returns the paragraphs containing the phrase, from the cache if
+they are cached.
+*/
+func (c CachedStorage) QueryForPhrase(words []string, categories []string) ([]Paragraph, error) {
+  return c.QueryForPhraseWithOptions(words, QueryOptions{Categories: categories})
+}
+
+func (c CachedStorage) QueryForPhraseWithOptions(words []string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return c.EachParagraphForPhrase(words, options, handler)
+  })
+}
+
+/*
+EachParagraphForPhrase calls the handler with each paragraph containing the
+phrase, caching the results in the same way as EachParagraphForWord.
+*/
+func (c CachedStorage) EachParagraphForPhrase(words []string, options QueryOptions, handler ParagraphHandler) (error) {
+  return c.eachParagraph(queryCacheKey("phrase", strings.Join(words, " "), options), handler,
+    func(handler ParagraphHandler) (error) {
+      return c.Storage.EachParagraphForPhrase(words, options, handler)
+    })
+}
+
+/*
+eachParagraph calls the handler with the cached results of the query with the
+given key, or runs the query and caches its results if they aren't cached. The
+results are only held until there are more of them than the cache can hold.
+*/
+func (c CachedStorage) eachParagraph(key string, handler ParagraphHandler, query func(handler ParagraphHandler) (error)) (error) {
+  if err := c.Context().Err(); err != nil {
+    return err
+  }
+
+  paragraphs, generation, hit := c.cache.get(key)
+  if hit {
+    for _, paragraph := range paragraphs {
+      err := handler(paragraph)
+      if err == ErrStopIteration {
+        return nil
+      } else if err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+
+  stopped := false
+  cacheable := true
+  paragraphs = make([]Paragraph, 0)
+  err := query(func(paragraph Paragraph) (error) {
+    if cacheable && len(paragraphs) < c.cache.size {
+      paragraphs = append(paragraphs, paragraph)
+    } else {
+      cacheable = false
+      paragraphs = nil
+    }
+
+    err := handler(paragraph)
+    if err != nil {
+      stopped = true
+    }
+    return err
+  })
+
+  if err == nil && !stopped && cacheable {
+    c.cache.put(key, paragraphs, generation)
+  }
+  return err
+}
+
+/*
+queryCacheKey identifies a query by its kind, its text and its options.
+*/
+func queryCacheKey(kind, text string, options QueryOptions) (string) {
+  return fmt.Sprintf("%s\x00%s\x00%#v", kind, text, options)
+}
+
+/*
+get returns the cached results for the key, if there are any which haven't
+expired, along with the cache's current generation.
+*/
+func (q *queryCache) get(key string) ([]Paragraph, int, bool) {
+  q.Lock()
+  defer q.Unlock()
+
+  element, exists := q.entries[key]
+  if exists {
+    entry := element.Value.(*cacheEntry)
+    if q.ttl <= 0 || q.now().Before(entry.expires) {
+      q.recency.MoveToFront(element)
+      q.stats.Hits++
+      return entry.paragraphs, q.generation, true
+    }
+
+    q.remove(element)
+  }
+
+  q.stats.Misses++
+  return nil, q.generation, false
+}
+
+/*
+put caches the results of a query which was run during the given generation,
+unless the cache has been invalidated since then or they don't fit in it.
+*/
+func (q *queryCache) put(key string, paragraphs []Paragraph, generation int) {
+  q.Lock()
+  defer q.Unlock()
+
+  entry := &cacheEntry{key, paragraphs, q.now().Add(q.ttl)}
+  if entry.cost() > q.size || generation != q.generation {
+    return
+  }
+
+  if element, exists := q.entries[key]; exists {
+    q.remove(element)
+  }
+
+  q.entries[key] = q.recency.PushFront(entry)
+  q.paragraphs += entry.cost()
+  for q.paragraphs > q.size {
+    q.remove(q.recency.Back())
+  }
+}
+
+/*
+remove evicts an entry from the cache.
+*/
+func (q *queryCache) remove(element *list.Element) {
+  entry := element.Value.(*cacheEntry)
+  q.recency.Remove(element)
+  delete(q.entries, entry.key)
+  q.paragraphs -= entry.cost()
+}
+
+/*
+cost returns the number of paragraphs that the entry counts as in the cache,
+which is at least one even for a query without results.
+*/
+func (e *cacheEntry) cost() (int) {
+  if len(e.paragraphs) == 0 {
+    return 1
+  }
+  return len(e.paragraphs)
+}
+
+func (c CachedStorage) NeighboringParagraphs(paragraphId int, radius int) ([]Paragraph, error) {
+  return c.Storage.NeighboringParagraphs(paragraphId, radius)
+}
+
+func (c CachedStorage) PublicationParagraphs(publicationId int) ([]Paragraph, error) {
+  return c.Storage.PublicationParagraphs(publicationId)
+}
+
+/*
+AddPublication adds the publication to the underlying Storage and empties the
+cache, since any cached query may now match the publication's paragraphs.
+*/
+func (c CachedStorage) AddPublication(publication Publication) (error) {
+  defer c.Invalidate()
+  return c.Storage.AddPublication(publication)
+}
+
+/*
+RemovePublication removes the publication from the underlying Storage and
+empties the cache.
+*/
+func (c CachedStorage) RemovePublication(sourceID string) (int, error) {
+  defer c.Invalidate()
+  return c.Storage.RemovePublication(sourceID)
+}
+
+/*
+ReplacePublication replaces the publication in the underlying Storage and
+empties the cache.
+*/
+func (c CachedStorage) ReplacePublication(publication Publication) (int, error) {
+  defer c.Invalidate()
+  return c.Storage.ReplacePublication(publication)
+}
+
+func (c CachedStorage) GetPublication(publicationId int) (Publication, error) {
+  return c.Storage.GetPublication(publicationId)
+}
+
+func (c CachedStorage) GetPublicationBySourceID(sourceID string) (Publication, error) {
+  return c.Storage.GetPublicationBySourceID(sourceID)
+}
+
+func (c CachedStorage) ListPublications(filter PublicationFilter) ([]Publication, error) {
+  return c.Storage.ListPublications(filter)
+}
+
+func (c CachedStorage) CorpusStats() (CorpusStats, error) {
+  return c.Storage.CorpusStats()
+}
@@ -0,0 +1,195 @@
+package philarios
+
+import (
+  "context"
+  "testing"
+  "time"
+)
+
+func setupCachedStorage(size int, ttl time.Duration) (CachedStorage, error) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    return CachedStorage{}, err
+  }
+
+  return NewCachedStorage(storage, size, ttl), nil
+}
+
+func TestCachingQueriesInCachedStorage(t *testing.T) {
+  storage, err := setupCachedStorage(4, 0)
+  if err != nil {
+    t.Errorf("Error setting up cached storage and seeding with data: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Categories []string
+    ExpectedParagraphs int
+    ExpectedStats CacheStats
+  }{
+    {"georgiana", nil, 2, CacheStats{0, 1}},
+    {"georgiana", nil, 2, CacheStats{1, 1}},
+    {"georgiana", []string{"classic"}, 2, CacheStats{1, 2}},
+    {"river", nil, 1, CacheStats{1, 3}},
+    // The least recently used query for "georgiana" has been evicted.
+    {"georgiana", nil, 2, CacheStats{1, 4}},
+    {"river", nil, 1, CacheStats{2, 4}},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWord(fixture.Word, fixture.Categories)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, len(paragraphs))
+    }
+    if stats := storage.CacheStats(); stats != fixture.ExpectedStats {
+      t.Errorf("Should have obtained cache stats %+v after querying for %q, instead obtained %+v",
+        fixture.ExpectedStats, fixture.Word, stats)
+    }
+  }
+}
+
+func TestInvalidatingCachedStorage(t *testing.T) {
+  storage, err := setupCachedStorage(10, 0)
+  if err != nil {
+    t.Errorf("Error setting up cached storage and seeding with data: %s", err.Error())
+  }
+
+  paragraphs, _ := storage.QueryForWord("river", nil)
+  if len(paragraphs) != 1 {
+    t.Errorf("Should have obtained 1 paragraph for 'river', instead obtained %d", len(paragraphs))
+  }
+
+  err = storage.AddPublication(Publication{SourceID: "river", Text: "The river wound towards the sea."})
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  paragraphs, _ = storage.QueryForWord("river", nil)
+  if len(paragraphs) != 2 {
+    t.Errorf("Should have obtained 2 paragraphs for 'river' once the cache was invalidated, instead obtained %d", len(paragraphs))
+  }
+
+  _, err = storage.RemovePublication("river")
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when removing a publication: %s", err.Error())
+  }
+
+  paragraphs, _ = storage.QueryForWord("river", nil)
+  if len(paragraphs) != 1 {
+    t.Errorf("Should have obtained 1 paragraph for 'river' once the cache was invalidated, instead obtained %d", len(paragraphs))
+  }
+
+  expected := CacheStats{0, 3}
+  if stats := storage.CacheStats(); stats != expected {
+    t.Errorf("Should have obtained cache stats %+v, instead obtained %+v", expected, stats)
+  }
+}
+
+func TestExpiringResultsInCachedStorage(t *testing.T) {
+  storage, err := setupCachedStorage(10, time.Minute)
+  if err != nil {
+    t.Errorf("Error setting up cached storage and seeding with data: %s", err.Error())
+  }
+
+  now := time.Date(2014, 8, 1, 12, 0, 0, 0, time.UTC)
+  storage.cache.now = func() (time.Time) {
+    return now
+  }
+
+  fixtures := []struct {
+    Elapsed time.Duration
+    ExpectedStats CacheStats
+  }{
+    {0, CacheStats{0, 1}},
+    {30 * time.Second, CacheStats{1, 1}},
+    {time.Minute, CacheStats{1, 2}},
+    {time.Minute + 30 * time.Second, CacheStats{2, 2}},
+  }
+
+  for _, fixture := range fixtures {
+    now = time.Date(2014, 8, 1, 12, 0, 0, 0, time.UTC).Add(fixture.Elapsed)
+    _, err := storage.QueryForPhrase([]string{"the", "river"}, nil)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for phrase: %s", err.Error())
+    }
+
+    if stats := storage.CacheStats(); stats != fixture.ExpectedStats {
+      t.Errorf("Should have obtained cache stats %+v after %s, instead obtained %+v",
+        fixture.ExpectedStats, fixture.Elapsed, stats)
+    }
+  }
+}
+
+func TestStoppedIterationIsNotCachedInCachedStorage(t *testing.T) {
+  storage, err := setupCachedStorage(10, 0)
+  if err != nil {
+    t.Errorf("Error setting up cached storage and seeding with data: %s", err.Error())
+  }
+
+  for i := 0; i < 2; i++ {
+    handled := 0
+    err = storage.EachParagraphForWord("georgiana", QueryOptions{}, func(paragraph Paragraph) (error) {
+      handled++
+      return ErrStopIteration
+    })
+    if err != nil || handled != 1 {
+      t.Errorf("Should have handled 1 paragraph without an error, instead handled %d (err=%v)", handled, err)
+    }
+  }
+
+  paragraphs, _ := storage.QueryForWord("georgiana", nil)
+  if len(paragraphs) != 2 {
+    t.Errorf("Should have obtained 2 paragraphs for 'georgiana', instead obtained %d", len(paragraphs))
+  }
+
+  expected := CacheStats{0, 3}
+  if stats := storage.CacheStats(); stats != expected {
+    t.Errorf("Should have obtained cache stats %+v, instead obtained %+v", expected, stats)
+  }
+}
+
+func TestLargeResultsAreNotCachedInCachedStorage(t *testing.T) {
+  storage, err := setupCachedStorage(1, 0)
+  if err != nil {
+    t.Errorf("Error setting up cached storage and seeding with data: %s", err.Error())
+  }
+
+  for i := 0; i < 2; i++ {
+    paragraphs, _ := storage.QueryForWord("georgiana", nil)
+    if len(paragraphs) != 2 {
+      t.Errorf("Should have obtained 2 paragraphs for 'georgiana', instead obtained %d", len(paragraphs))
+    }
+  }
+
+  expected := CacheStats{0, 2}
+  if stats := storage.CacheStats(); stats != expected {
+    t.Errorf("Should have obtained cache stats %+v, instead obtained %+v", expected, stats)
+  }
+  if storage.cache.paragraphs != 0 {
+    t.Errorf("Shouldn't have held any paragraphs in the cache, instead held %d", storage.cache.paragraphs)
+  }
+}
+
+func TestCancelledContextInCachedStorage(t *testing.T) {
+  storage, err := setupCachedStorage(10, 0)
+  if err != nil {
+    t.Errorf("Error setting up cached storage and seeding with data: %s", err.Error())
+  }
+
+  _, err = storage.QueryForWord("river", nil)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  _, err = storage.WithContext(ctx).QueryForWord("river", nil)
+  if err != context.Canceled {
+    t.Errorf("Should have obtained context.Canceled for a cached query, instead obtained %v", err)
+  }
+}
@@ -0,0 +1,180 @@
+package philarios
+
+import (
+  "database/sql"
+  "fmt"
+  "strings"
+
+  "github.com/lib/pq"
+)
+
+/*
+PublicationFilter selects the publications returned by ListPublications. Empty
+fields don't restrict the publications. StartDate and EndDate are inclusive
+bounds in the form YYYY-MM-DD. Publications are listed in order of their ids,
+starting after the id AfterID, skipping the first Offset of them and returning
+at most Limit of them when Limit is positive. To list publications a page at a
+time, set AfterID to the id of the last publication of the previous page
+rather than increasing Offset, which gets slower with every page and skips
+publications when earlier ones are removed in between.
+*/
+type PublicationFilter struct {
+  Author string
+  Type string
+  Category string
+  StartDate string
+  EndDate string
+  AfterID int
+  Offset int
+  Limit int
+}
+
+/*
+publicationColumns are the columns of the publications table which are scanned
+into a Publication by scanPublication.
+*/
+const publicationColumns = `publications.id, COALESCE(publications.title, ''),
+  COALESCE(publications.author, ''), COALESCE(publications.editor, ''),
+  COALESCE(to_char(publications.date, 'YYYY-MM-DD'), ''),
+  COALESCE(publications.source_id, ''), COALESCE(publications.source_url, ''),
+  COALESCE(publications.encoding, ''), COALESCE(publications.type, ''),
+  publications.language,
+  ARRAY(SELECT category FROM categories
+    WHERE categories.publication = publications.id
+    ORDER BY categories.id)`
+
+type rowScanner interface {
+  Scan(dest ...interface{}) (error)
+}
+
+func scanPublication(row rowScanner) (Publication, error) {
+  var publication Publication
+  err := row.Scan(
+    &publication.ID,
+    &publication.Title,
+    &publication.Author,
+    &publication.Editor,
+    &publication.Date,
+    &publication.SourceID,
+    &publication.SourceURL,
+    &publication.Encoding,
+    &publication.Type,
+    &publication.Language,
+    pq.Array(&publication.Categories))
+
+  return publication, err
+}
+
+/*
+GetPublication returns the publication with the given id, along with its
+categories. The publication's Text is not filled in, since it is only stored
+in the form of paragraphs. ErrPublicationNotFound is returned if there is no
+such publication.
+*/
+func (p PostgresStorage) GetPublication(publicationId int) (Publication, error) {
+  return p.getPublication(`publications.id=$1`, publicationId)
+}
+
+/*
+GetPublicationBySourceID returns the publication with the given SourceID in
+the same way as GetPublication.
+*/
+func (p PostgresStorage) GetPublicationBySourceID(sourceID string) (Publication, error) {
+  return p.getPublication(`publications.source_id=$1`, sourceID)
+}
+
+func (p PostgresStorage) getPublication(condition string, value interface{}) (Publication, error) {
+  row := p.SQLDatabase.QueryRowContext(p.Context(), `SELECT ` + publicationColumns + `
+    FROM publications WHERE ` + condition, value)
+
+  publication, err := scanPublication(row)
+  if err == sql.ErrNoRows {
+    return publication, ErrPublicationNotFound
+  }
+
+  return publication, err
+}
+
+/*
+ListPublications returns the publications selected by the filter, along with
+their categories. As with GetPublication, the publications' Text is not
+filled in.
+*/
+func (p PostgresStorage) ListPublications(filter PublicationFilter) ([]Publication, error) {
+  var args queryArgs
+  conditions := []string{"TRUE"}
+  if filter.Author != "" {
+    conditions = append(conditions, "publications.author = " + args.add(filter.Author))
+  }
+  if filter.Type != "" {
+    conditions = append(conditions, "publications.type = " + args.add(filter.Type))
+  }
+  if filter.Category != "" {
+    conditions = append(conditions, fmt.Sprintf(`publications.id IN (
+      SELECT publication FROM categories WHERE category = %s)`, args.add(filter.Category)))
+  }
+  if filter.StartDate != "" {
+    conditions = append(conditions, fmt.Sprintf("publications.date >= %s::date", args.add(filter.StartDate)))
+  }
+  if filter.EndDate != "" {
+    conditions = append(conditions, fmt.Sprintf("publications.date <= %s::date", args.add(filter.EndDate)))
+  }
+  if filter.AfterID > 0 {
+    conditions = append(conditions, "publications.id > " + args.add(filter.AfterID))
+  }
+
+  query := `SELECT ` + publicationColumns + ` FROM publications
+    WHERE ` + strings.Join(conditions, " AND ") + `
+    ORDER BY publications.id`
+  if filter.Offset > 0 {
+    query += " OFFSET " + args.add(filter.Offset)
+  }
+  if filter.Limit > 0 {
+    query += " LIMIT " + args.add(filter.Limit)
+  }
+
+  rows, err := p.SQLDatabase.QueryContext(p.Context(), query, args...)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  publications := make([]Publication, 0)
+  for rows.Next() {
+    publication, err := scanPublication(rows)
+    if err != nil {
+      return nil, err
+    }
+    publications = append(publications, publication)
+  }
+
+  if err = rows.Err(); err != nil {
+    return nil, err
+  }
+
+  return publications, nil
+}
+
+/*
+matchesFilter returns whether the publication is selected by the filter,
+ignoring the filter's AfterID, Offset and Limit.
+*/
+func matchesFilter(publication Publication, filter PublicationFilter) (bool) {
+  if filter.Author != "" && publication.Author != filter.Author {
+    return false
+  }
+  if filter.Type != "" && publication.Type != filter.Type {
+    return false
+  }
+  if filter.Category != "" && !matchesCategories(publication.Categories, QueryOptions{Categories: []string{filter.Category}}) {
+    return false
+  }
+  if filter.StartDate != "" && (publication.Date == "" || publication.Date < filter.StartDate) {
+    return false
+  }
+  if filter.EndDate != "" && (publication.Date == "" || publication.Date > filter.EndDate) {
+    return false
+  }
+
+  return true
+}
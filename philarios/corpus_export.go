@@ -0,0 +1,235 @@
+package philarios
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "strings"
+)
+
+/*
+CorpusFormat and CorpusFormatVersion identify the files written by
+ExportCorpus. The version is increased whenever a change to the format would
+prevent older versions of ImportCorpus from reading a file.
+*/
+const (
+  CorpusFormat = "updike-corpus"
+  CorpusFormatVersion = 1
+)
+
+/*
+exportPageSize is the number of publications which are listed from storage at
+a time while exporting a corpus.
+*/
+const exportPageSize = 1000
+
+/*
+corpusHeader is the first line of an exported corpus.
+*/
+type corpusHeader struct {
+  Format string `json:"format"`
+  Version int `json:"version"`
+}
+
+/*
+exportedPublication is a line of an exported corpus, which holds a publication
+along with its categories and paragraphs. Storage ids are not exported, since
+they are assigned afresh when the corpus is imported.
+*/
+type exportedPublication struct {
+  Title string `json:"title,omitempty"`
+  Author string `json:"author,omitempty"`
+  Editor string `json:"editor,omitempty"`
+  Date string `json:"date,omitempty"`
+  SourceID string `json:"source_id"`
+  SourceURL string `json:"source_url,omitempty"`
+  Encoding string `json:"encoding,omitempty"`
+  Type string `json:"type,omitempty"`
+  Language string `json:"language,omitempty"`
+  Categories []string `json:"categories"`
+  Paragraphs []exportedParagraph `json:"paragraphs"`
+}
+
+type exportedParagraph struct {
+  Body string `json:"body"`
+  StartOffset int `json:"start_offset"`
+  EndOffset int `json:"end_offset"`
+}
+
+/*
+ExportCorpus writes every publication in storage, along with its categories
+and paragraphs, to w in the JSON Lines format and returns the number of
+publications written. The first line is a header naming the format, and each
+of the following lines holds a single publication. Publications which are
+added or removed while the corpus is being exported may or may not be
+included.
+*/
+func ExportCorpus(storage Storage, w io.Writer) (int, error) {
+  encoder := json.NewEncoder(w)
+  err := encoder.Encode(corpusHeader{CorpusFormat, CorpusFormatVersion})
+  if err != nil {
+    return 0, err
+  }
+
+  exported := 0
+  lastId := 0
+  for {
+    publications, err := storage.ListPublications(PublicationFilter{
+      AfterID: lastId,
+      Limit: exportPageSize,
+    })
+    if err != nil {
+      return exported, err
+    }
+    if len(publications) > 0 {
+      lastId = publications[len(publications) - 1].ID
+    }
+
+    for _, publication := range publications {
+      paragraphs, err := storage.PublicationParagraphs(publication.ID)
+      if err == ErrPublicationNotFound {
+        // The publication was removed since it was listed.
+        continue
+      } else if err != nil {
+        return exported, err
+      }
+
+      err = encoder.Encode(exportPublication(publication, paragraphs))
+      if err != nil {
+        return exported, err
+      }
+      exported++
+    }
+
+    if len(publications) < exportPageSize {
+      return exported, nil
+    }
+  }
+}
+
+func exportPublication(publication Publication, paragraphs []Paragraph) (exportedPublication) {
+  exported := exportedPublication{
+    Title: publication.Title,
+    Author: publication.Author,
+    Editor: publication.Editor,
+    Date: publication.Date,
+    SourceID: publication.SourceID,
+    SourceURL: publication.SourceURL,
+    Encoding: publication.Encoding,
+    Type: publication.Type,
+    Language: publication.Language,
+    Categories: append([]string{}, publication.Categories...),
+    Paragraphs: make([]exportedParagraph, len(paragraphs)),
+  }
+
+  for i, paragraph := range paragraphs {
+    exported.Paragraphs[i] = exportedParagraph{
+      Body: paragraph.Body,
+      StartOffset: paragraph.StartOffset,
+      EndOffset: paragraph.EndOffset,
+    }
+  }
+
+  return exported
+}
+
+/*
+ImportCorpus reads a corpus written by ExportCorpus from r and adds each of its
+publications to storage, returning the number of publications read. As with
+AddPublication, publications whose SourceID is already in storage are left
+untouched. The text of each publication is rebuilt from its paragraphs, so
+that the paragraphs keep their positions and offsets.
+*/
+func ImportCorpus(storage Storage, r io.Reader) (int, error) {
+  decoder := json.NewDecoder(r)
+
+  var header corpusHeader
+  err := decoder.Decode(&header)
+  if err != nil {
+    return 0, fmt.Errorf("Could not read corpus header: %s", err.Error())
+  }
+  if header.Format != CorpusFormat {
+    return 0, fmt.Errorf("Unknown corpus format %q", header.Format)
+  }
+  if header.Version < 1 || header.Version > CorpusFormatVersion {
+    return 0, fmt.Errorf("Unsupported corpus format version %d", header.Version)
+  }
+
+  imported := 0
+  for {
+    var exported exportedPublication
+    err = decoder.Decode(&exported)
+    if err == io.EOF {
+      return imported, nil
+    } else if err != nil {
+      return imported, fmt.Errorf("Could not read publication %d of corpus: %s", imported + 1, err.Error())
+    }
+
+    err = storage.AddPublication(importPublication(exported))
+    if err != nil {
+      return imported, err
+    }
+    imported++
+  }
+}
+
+func importPublication(exported exportedPublication) (Publication) {
+  return Publication{
+    Title: exported.Title,
+    Author: exported.Author,
+    Editor: exported.Editor,
+    Date: exported.Date,
+    SourceID: exported.SourceID,
+    SourceURL: exported.SourceURL,
+    Encoding: exported.Encoding,
+    Type: exported.Type,
+    Language: exported.Language,
+    Text: publicationText(exported.Paragraphs),
+    Categories: exported.Categories,
+  }
+}
+
+/*
+publicationText rebuilds the text of a publication from its paragraphs. When
+the paragraphs' offsets were recorded, each paragraph is placed at its offset
+and the gaps between them are filled with newlines, so that splitting the text
+into paragraphs again gives the same offsets. Otherwise the paragraphs are
+simply joined by newlines.
+*/
+func publicationText(paragraphs []exportedParagraph) (string) {
+  if !hasConsistentOffsets(paragraphs) {
+    bodies := make([]string, len(paragraphs))
+    for i, paragraph := range paragraphs {
+      bodies[i] = paragraph.Body
+    }
+    return strings.Join(bodies, "\n")
+  }
+
+  var text []byte
+  for _, paragraph := range paragraphs {
+    for len(text) < paragraph.StartOffset {
+      text = append(text, '\n')
+    }
+    text = append(text, paragraph.Body...)
+  }
+
+  return string(text)
+}
+
+/*
+hasConsistentOffsets returns whether the offsets of the paragraphs match their
+bodies, and leave room for a newline between each paragraph and the next.
+*/
+func hasConsistentOffsets(paragraphs []exportedParagraph) (bool) {
+  previousEnd := -1
+  for _, paragraph := range paragraphs {
+    if paragraph.StartOffset <= previousEnd ||
+      paragraph.EndOffset - paragraph.StartOffset != len(paragraph.Body) ||
+      strings.Contains(paragraph.Body, "\n") {
+      return false
+    }
+    previousEnd = paragraph.EndOffset
+  }
+
+  return true
+}
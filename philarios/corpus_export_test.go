@@ -0,0 +1,104 @@
+package philarios
+
+import (
+  "bytes"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestExportingAndImportingCorpus(t *testing.T) {
+  source, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  err = source.AddPublication(Publication{
+    Title: "Pip",
+    SourceID: "pip-neighbors",
+    Language: "french",
+    Text: "Pip, sir.\nOnce more, said the man.\n\n  Give it mouth!\nPip. Pip, sir.",
+  })
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  var corpus bytes.Buffer
+  exported, err := ExportCorpus(source, &corpus)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when exporting the corpus: %s", err.Error())
+  }
+  if exported != 4 {
+    t.Errorf("Should have exported 4 publications, instead exported %d", exported)
+  }
+
+  destination := NewMemoryStorage()
+  imported, err := ImportCorpus(destination, &corpus)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when importing the corpus: %s", err.Error())
+  }
+  if imported != 4 {
+    t.Errorf("Should have imported 4 publications, instead imported %d", imported)
+  }
+
+  expectedPublications, _ := source.ListPublications(PublicationFilter{})
+  publications, _ := destination.ListPublications(PublicationFilter{})
+  if !reflect.DeepEqual(publications, expectedPublications) {
+    t.Errorf("Imported publications %+v differ from exported publications %+v", publications, expectedPublications)
+  }
+
+  for _, publication := range expectedPublications {
+    expectedParagraphs, _ := source.PublicationParagraphs(publication.ID)
+    paragraphs, err := destination.PublicationParagraphs(publication.ID)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when obtaining paragraphs: %s", err.Error())
+    }
+    if !reflect.DeepEqual(paragraphs, expectedParagraphs) {
+      t.Errorf("Imported paragraphs %+v differ from exported paragraphs %+v", paragraphs, expectedParagraphs)
+    }
+  }
+}
+
+func TestImportingMalformedCorpus(t *testing.T) {
+  fixtures := []struct {
+    Corpus string
+    ExpectedImported int
+    ExpectedError bool
+  }{
+    {"", 0, true},
+    {`{"format":"something-else","version":1}`, 0, true},
+    {`{"format":"updike-corpus","version":2}`, 0, true},
+    {`{"format":"updike-corpus","version":1}`, 0, false},
+    {`{"format":"updike-corpus","version":1}
+{"source_id":"a","paragraphs":[{"body":"First.","start_offset":-1,"end_offset":-1}]}
+{"source_id":"b","paragraphs":`, 1, true},
+  }
+
+  for _, fixture := range fixtures {
+    imported, err := ImportCorpus(NewMemoryStorage(), strings.NewReader(fixture.Corpus))
+    if imported != fixture.ExpectedImported || (err != nil) != fixture.ExpectedError {
+      t.Errorf("Should have imported %d publications (error: %t) from %q, instead imported %d (err=%v)",
+        fixture.ExpectedImported, fixture.ExpectedError, fixture.Corpus, imported, err)
+    }
+  }
+}
+
+func TestRebuildingPublicationText(t *testing.T) {
+  fixtures := []struct {
+    Paragraphs []exportedParagraph
+    ExpectedText string
+  }{
+    {[]exportedParagraph{}, ""},
+    {[]exportedParagraph{{"Pip.", 2, 6}, {"Sir.", 8, 12}}, "\n\nPip.\n\nSir."},
+    {[]exportedParagraph{{"Pip.", -1, -1}, {"Sir.", -1, -1}}, "Pip.\nSir."},
+    {[]exportedParagraph{{"Pip.", 0, 4}, {"Sir.", 4, 8}}, "Pip.\nSir."},
+    {[]exportedParagraph{{"Pip.", 0, 10}}, "Pip."},
+  }
+
+  for _, fixture := range fixtures {
+    text := publicationText(fixture.Paragraphs)
+    if text != fixture.ExpectedText {
+      t.Errorf("Should have rebuilt %q from %+v, instead rebuilt %q", fixture.ExpectedText, fixture.Paragraphs, text)
+    }
+  }
+}
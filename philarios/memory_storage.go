@@ -0,0 +1,706 @@
+package philarios
+
+import (
+  "context"
+  "math"
+  "sort"
+  "strings"
+  "sync"
+
+  "github.com/reiver/go-porterstemmer"
+  "github.com/wangjohn/updike/textprocessor"
+)
+
+/*
+MemoryStorage is an implementation of Storage which keeps an inverted index of
+paragraphs in memory. It is meant for tests and for small corpora which don't
+justify running a database. A MemoryStorage should be created with
+NewMemoryStorage, and copies of it share the same underlying index. Since the
+index is in memory, a context given to WithContext is only checked between
+steps of each call rather than interrupting them.
+*/
+type MemoryStorage struct {
+  index *memoryIndex
+  ctx context.Context
+}
+
+type memoryIndex struct {
+  sync.RWMutex
+  lastPublicationId int
+  publications map[int]Publication
+  sourceIDs map[string]int
+  publicationParagraphs map[int][]int
+  paragraphs []Paragraph
+  postings map[string][]int
+}
+
+/*
+NewMemoryStorage returns an empty MemoryStorage.
+*/
+func NewMemoryStorage() (MemoryStorage) {
+  return MemoryStorage{index: &memoryIndex{
+    publications: make(map[int]Publication),
+    sourceIDs: make(map[string]int),
+    publicationParagraphs: make(map[int][]int),
+    postings: make(map[string][]int),
+  }}
+}
+
+/*
+WithContext returns a copy of the storage which checks ctx in each of its
+calls. The copy shares the same index.
+*/
+func (m MemoryStorage) WithContext(ctx context.Context) (Storage) {
+  m.ctx = ctx
+  return m
+}
+
+/*
+Context returns the context that the storage's calls check, which is
+context.Background() unless one was given to WithContext.
+*/
+func (m MemoryStorage) Context() (context.Context) {
+  if m.ctx == nil {
+    return context.Background()
+  }
+  return m.ctx
+}
+
+/*
+QueryForWord returns the paragraphs containing the query word given as an
+argument. Words are matched on their stemmed, lower-cased form, similar to the
+full text search used by PostgresStorage. If categories is non-empty, only
+paragraphs from publications tagged with any of the categories are returned.
+*/
+func (m MemoryStorage) QueryForWord(word string, categories []string) ([]Paragraph, error) {
+  return m.QueryForWordWithOptions(word, QueryOptions{Categories: categories})
+}
+
+/*
+QueryForWordWithOptions returns the paragraphs containing the query word which
+also satisfy the given options.
+*/
+func (m MemoryStorage) QueryForWordWithOptions(word string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return m.EachParagraphForWord(word, options, handler)
+  })
+}
+
+/*
+EachParagraphForWord calls the handler with each paragraph containing the query
+word which also satisfies the given options. The handler is called after the
+index has been unlocked, so it may use the storage itself.
+*/
+func (m MemoryStorage) EachParagraphForWord(word string, options QueryOptions, handler ParagraphHandler) (error) {
+  tokens := searchTokens(word, queryLanguage(options))
+  return m.eachMatchedParagraph(tokens, false, options, handler)
+}
+
+/*
+QueryForPhrase returns the paragraphs containing the words of the phrase next
+to each other and in order. If categories is non-empty, only paragraphs from
+publications tagged with any of the categories are returned.
+*/
+func (m MemoryStorage) QueryForPhrase(words []string, categories []string) ([]Paragraph, error) {
+  return m.QueryForPhraseWithOptions(words, QueryOptions{Categories: categories})
+}
+
+/*
+QueryForPhraseWithOptions returns the paragraphs containing the phrase which
+also satisfy the given options.
+*/
+func (m MemoryStorage) QueryForPhraseWithOptions(words []string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return m.EachParagraphForPhrase(words, options, handler)
+  })
+}
+
+/*
+EachParagraphForPhrase calls the handler with each paragraph containing the
+phrase which also satisfies the given options. Unlike PostgresStorage, every
+word of the phrase is significant, including stop words.
+*/
+func (m MemoryStorage) EachParagraphForPhrase(words []string, options QueryOptions, handler ParagraphHandler) (error) {
+  tokens := searchTokens(strings.Join(words, " "), queryLanguage(options))
+  return m.eachMatchedParagraph(tokens, true, options, handler)
+}
+
+func (m MemoryStorage) eachMatchedParagraph(tokens []string, phrase bool, options QueryOptions, handler ParagraphHandler) (error) {
+  ctx := m.Context()
+  if err := ctx.Err(); err != nil {
+    return err
+  }
+
+  for _, paragraph := range m.matchParagraphs(tokens, phrase, options) {
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+
+    err := handler(paragraph)
+    if err == ErrStopIteration {
+      return nil
+    } else if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+/*
+matchParagraphs returns the paragraphs containing every one of the tokens, or
+containing them as a contiguous phrase if phrase is set, which also satisfy
+the given options.
+*/
+func (m MemoryStorage) matchParagraphs(tokens []string, phrase bool, options QueryOptions) ([]Paragraph) {
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  language := queryLanguage(options)
+  paragraphs := make([]Paragraph, 0)
+  seenContent := make(map[string]bool)
+  for _, paragraphIndex := range m.index.matchingParagraphs(tokens) {
+    if !options.Ranked && options.Limit > 0 && len(paragraphs) >= options.Limit {
+      break
+    }
+
+    paragraph := m.index.paragraphs[paragraphIndex]
+    if !m.index.matchesOptions(paragraph, options) {
+      continue
+    }
+
+    // Paragraphs are matched in the order in which they were added, so the
+    // first of a set of matched duplicates is the one that is kept.
+    if !options.IncludeDuplicates && seenContent[paragraph.ContentHash] {
+      continue
+    }
+
+    bodyTokens := searchTokens(paragraph.Body, language)
+    var occurrences int
+    if phrase {
+      occurrences = phraseOccurrences(bodyTokens, tokens)
+    } else {
+      occurrences = tokenOccurrences(bodyTokens, tokens)
+    }
+
+    if occurrences > 0 {
+      paragraph.Score = rankParagraph(occurrences, len(bodyTokens))
+      paragraphs = append(paragraphs, paragraph)
+      seenContent[paragraph.ContentHash] = true
+    }
+  }
+
+  if options.Ranked {
+    sort.Stable(byScore(paragraphs))
+    if options.Limit > 0 && len(paragraphs) > options.Limit {
+      paragraphs = paragraphs[:options.Limit]
+    }
+  }
+
+  return paragraphs
+}
+
+/*
+rankParagraph scores a paragraph by the number of times the query occurs in
+it, divided by one plus the logarithm of its length. This mirrors the ts_rank
+normalization used by PostgresStorage.
+*/
+func rankParagraph(occurrences, length int) (float64) {
+  return float64(occurrences) / (1.0 + math.Log(float64(length)))
+}
+
+func tokenOccurrences(bodyTokens, queryTokens []string) (int) {
+  isQueryToken := make(map[string]bool)
+  for _, token := range queryTokens {
+    isQueryToken[token] = true
+  }
+
+  occurrences := 0
+  for _, token := range bodyTokens {
+    if isQueryToken[token] {
+      occurrences++
+    }
+  }
+
+  return occurrences
+}
+
+func phraseOccurrences(bodyTokens, phraseTokens []string) (int) {
+  occurrences := 0
+  for i := 0; i + len(phraseTokens) <= len(bodyTokens); i++ {
+    if stringsEqual(bodyTokens[i:i + len(phraseTokens)], phraseTokens) {
+      occurrences++
+    }
+  }
+
+  return occurrences
+}
+
+func stringsEqual(a, b []string) (bool) {
+  if len(a) != len(b) {
+    return false
+  }
+
+  for i := range a {
+    if a[i] != b[i] {
+      return false
+    }
+  }
+  return true
+}
+
+/*
+byScore sorts paragraphs in decreasing order of their scores.
+*/
+type byScore []Paragraph
+
+func (b byScore) Len() int {
+  return len(b)
+}
+
+func (b byScore) Less(i, j int) bool {
+  return b[i].Score > b[j].Score
+}
+
+func (b byScore) Swap(i, j int) {
+  b[i], b[j] = b[j], b[i]
+}
+
+/*
+AddPublication adds a new publication to the index. As with PostgresStorage, a
+publication whose SourceID has already been added is ignored.
+*/
+func (m MemoryStorage) AddPublication(publication Publication) (error) {
+  return m.addPublication(publication, false)
+}
+
+/*
+UpsertPublication adds a publication in the same way as AddPublication, except
+that if a publication with the same SourceID already exists, its metadata,
+categories and paragraphs are replaced by those of the given publication. The
+existing publication keeps its id.
+*/
+func (m MemoryStorage) UpsertPublication(publication Publication) (error) {
+  return m.addPublication(publication, true)
+}
+
+/*
+RemovePublication removes the publication with the given SourceID, along with
+its paragraphs, and returns the id that the publication had. If no such
+publication exists, ErrPublicationNotFound is returned.
+*/
+func (m MemoryStorage) RemovePublication(sourceID string) (int, error) {
+  if err := m.Context().Err(); err != nil {
+    return 0, err
+  }
+
+  m.index.Lock()
+  defer m.index.Unlock()
+
+  publicationId, exists := m.index.sourceIDs[sourceID]
+  if !exists {
+    return 0, ErrPublicationNotFound
+  }
+
+  m.index.removeParagraphs(publicationId)
+  delete(m.index.publications, publicationId)
+  delete(m.index.sourceIDs, sourceID)
+
+  return publicationId, nil
+}
+
+/*
+ReplacePublication replaces the metadata, categories and paragraphs of the
+existing publication with the same SourceID, and returns its id. If there is
+no publication to replace, ErrPublicationNotFound is returned.
+*/
+func (m MemoryStorage) ReplacePublication(publication Publication) (int, error) {
+  if err := m.Context().Err(); err != nil {
+    return 0, err
+  }
+
+  paragraphs, err := textprocessor.ProcessParagraphSpans(publication.Text)
+  if err != nil {
+    return 0, err
+  }
+
+  m.index.Lock()
+  defer m.index.Unlock()
+
+  if _, exists := m.index.sourceIDs[publication.SourceID]; !exists {
+    return 0, ErrPublicationNotFound
+  }
+
+  return m.index.storePublication(publication, paragraphs), nil
+}
+
+func (m MemoryStorage) addPublication(publication Publication, replaceExisting bool) (error) {
+  if err := m.Context().Err(); err != nil {
+    return err
+  }
+
+  paragraphs, err := textprocessor.ProcessParagraphSpans(publication.Text)
+  if err != nil {
+    return err
+  }
+
+  m.index.Lock()
+  defer m.index.Unlock()
+
+  if _, exists := m.index.sourceIDs[publication.SourceID]; exists && !replaceExisting {
+    return nil
+  }
+
+  m.index.storePublication(publication, paragraphs)
+  return nil
+}
+
+/*
+storePublication adds the publication and its paragraphs to the index and
+returns its id. If a publication with the same SourceID exists, it is replaced
+and keeps its id.
+*/
+func (m *memoryIndex) storePublication(publication Publication, paragraphs []textprocessor.ParagraphSpan) (int) {
+  publicationId, exists := m.sourceIDs[publication.SourceID]
+  if exists {
+    m.removeParagraphs(publicationId)
+  } else {
+    m.lastPublicationId++
+    publicationId = m.lastPublicationId
+    m.sourceIDs[publication.SourceID] = publicationId
+  }
+
+  // The text is only kept in the form of paragraphs.
+  publication.ID = publicationId
+  publication.Language = publicationLanguage(publication)
+  publication.Text = ""
+  publication.Categories = append([]string{}, publication.Categories...)
+  m.publications[publicationId] = publication
+
+  for i, span := range paragraphs {
+    m.addParagraph(Paragraph{
+      PublicationId: publicationId,
+      Position: i,
+      StartOffset: span.Start,
+      EndOffset: span.End,
+      Body: span.Text,
+      ContentHash: ContentHash(span.Text),
+    })
+  }
+
+  return publicationId
+}
+
+/*
+addParagraph adds a paragraph to the index. Paragraphs of a publication must be
+added in order of their positions.
+*/
+func (m *memoryIndex) addParagraph(paragraph Paragraph) {
+  paragraphIndex := len(m.paragraphs)
+  paragraph.ID = paragraphIndex + 1
+  m.paragraphs = append(m.paragraphs, paragraph)
+  m.publicationParagraphs[paragraph.PublicationId] = append(
+    m.publicationParagraphs[paragraph.PublicationId], paragraphIndex)
+
+  seen := make(map[string]bool)
+  language := publicationLanguage(m.publications[paragraph.PublicationId])
+  for _, token := range searchTokens(paragraph.Body, language) {
+    if !seen[token] {
+      m.postings[token] = append(m.postings[token], paragraphIndex)
+      seen[token] = true
+    }
+  }
+}
+
+/*
+GetPublication returns the publication with the given id, along with its
+categories. The publication's Text is not filled in, since it is only kept in
+the form of paragraphs. ErrPublicationNotFound is returned if there is no such
+publication.
+*/
+func (m MemoryStorage) GetPublication(publicationId int) (Publication, error) {
+  if err := m.Context().Err(); err != nil {
+    return Publication{}, err
+  }
+
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  publication, exists := m.index.publications[publicationId]
+  if !exists {
+    return Publication{}, ErrPublicationNotFound
+  }
+
+  return copyPublication(publication), nil
+}
+
+/*
+GetPublicationBySourceID returns the publication with the given SourceID in
+the same way as GetPublication.
+*/
+func (m MemoryStorage) GetPublicationBySourceID(sourceID string) (Publication, error) {
+  m.index.RLock()
+  publicationId, exists := m.index.sourceIDs[sourceID]
+  m.index.RUnlock()
+  if !exists {
+    return Publication{}, ErrPublicationNotFound
+  }
+
+  return m.GetPublication(publicationId)
+}
+
+/*
+ListPublications returns the publications selected by the filter, along with
+their categories. As with GetPublication, the publications' Text is not
+filled in.
+*/
+func (m MemoryStorage) ListPublications(filter PublicationFilter) ([]Publication, error) {
+  if err := m.Context().Err(); err != nil {
+    return nil, err
+  }
+
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  publicationIds := make([]int, 0, len(m.index.publications))
+  for publicationId := range m.index.publications {
+    publicationIds = append(publicationIds, publicationId)
+  }
+  sort.Ints(publicationIds)
+
+  publications := make([]Publication, 0)
+  skipped := 0
+  start := sort.SearchInts(publicationIds, filter.AfterID + 1)
+  for _, publicationId := range publicationIds[start:] {
+    if filter.Limit > 0 && len(publications) >= filter.Limit {
+      break
+    }
+
+    publication := m.index.publications[publicationId]
+    if !matchesFilter(publication, filter) {
+      continue
+    }
+
+    if skipped < filter.Offset {
+      skipped++
+      continue
+    }
+    publications = append(publications, copyPublication(publication))
+  }
+
+  return publications, nil
+}
+
+func copyPublication(publication Publication) (Publication) {
+  publication.Categories = append([]string{}, publication.Categories...)
+  return publication
+}
+
+/*
+NeighboringParagraphs returns the paragraph with the given id together with the
+paragraphs of the same publication which are at most radius positions away
+from it, in the order in which they appear in the publication.
+ErrParagraphNotFound is returned if there is no paragraph with the given id.
+*/
+func (m MemoryStorage) NeighboringParagraphs(paragraphId int, radius int) ([]Paragraph, error) {
+  if err := m.Context().Err(); err != nil {
+    return nil, err
+  }
+
+  if radius < 0 {
+    radius = 0
+  }
+
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  paragraphIndex := paragraphId - 1
+  if paragraphIndex < 0 || paragraphIndex >= len(m.index.paragraphs) {
+    return nil, ErrParagraphNotFound
+  }
+
+  target := m.index.paragraphs[paragraphIndex]
+  publicationParagraphs := m.index.publicationParagraphs[target.PublicationId]
+  if target.Position >= len(publicationParagraphs) || publicationParagraphs[target.Position] != paragraphIndex {
+    // The paragraph's publication has since been removed or replaced.
+    return nil, ErrParagraphNotFound
+  }
+
+  start := target.Position - radius
+  if start < 0 {
+    start = 0
+  }
+  end := target.Position + radius + 1
+  if end > len(publicationParagraphs) {
+    end = len(publicationParagraphs)
+  }
+
+  paragraphs := make([]Paragraph, 0, end - start)
+  for _, neighborIndex := range publicationParagraphs[start:end] {
+    paragraphs = append(paragraphs, m.index.paragraphs[neighborIndex])
+  }
+
+  return paragraphs, nil
+}
+
+/*
+PublicationParagraphs returns the paragraphs of the publication with the given
+id, in the order in which they appear in the publication.
+ErrPublicationNotFound is returned if there is no such publication.
+*/
+func (m MemoryStorage) PublicationParagraphs(publicationId int) ([]Paragraph, error) {
+  if err := m.Context().Err(); err != nil {
+    return nil, err
+  }
+
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  if _, exists := m.index.publications[publicationId]; !exists {
+    return nil, ErrPublicationNotFound
+  }
+
+  paragraphIndices := m.index.publicationParagraphs[publicationId]
+  paragraphs := make([]Paragraph, 0, len(paragraphIndices))
+  for _, paragraphIndex := range paragraphIndices {
+    paragraphs = append(paragraphs, m.index.paragraphs[paragraphIndex])
+  }
+
+  return paragraphs, nil
+}
+
+/*
+removeParagraphs removes the paragraphs of a publication from the postings, so
+that they are no longer returned by queries.
+*/
+func (m *memoryIndex) removeParagraphs(publicationId int) {
+  language := publicationLanguage(m.publications[publicationId])
+  for _, paragraphIndex := range m.publicationParagraphs[publicationId] {
+    for _, token := range searchTokens(m.paragraphs[paragraphIndex].Body, language) {
+      m.postings[token] = removePosting(m.postings[token], paragraphIndex)
+      if len(m.postings[token]) == 0 {
+        delete(m.postings, token)
+      }
+    }
+  }
+
+  delete(m.publicationParagraphs, publicationId)
+}
+
+func removePosting(postings []int, paragraphIndex int) ([]int) {
+  result := postings[:0]
+  for _, posting := range postings {
+    if posting != paragraphIndex {
+      result = append(result, posting)
+    }
+  }
+
+  return result
+}
+
+/*
+matchesOptions returns whether the publication of the paragraph satisfies the
+restrictions given by the options.
+*/
+func (m *memoryIndex) matchesOptions(paragraph Paragraph, options QueryOptions) (bool) {
+  publication := m.publications[paragraph.PublicationId]
+  if publicationLanguage(publication) != queryLanguage(options) {
+    return false
+  }
+  if len(options.Authors) > 0 && !containsString(options.Authors, publication.Author) {
+    return false
+  }
+
+  dates := PublicationFilter{StartDate: options.StartDate, EndDate: options.EndDate}
+  if !matchesFilter(publication, dates) {
+    return false
+  }
+
+  return matchesCategories(publication.Categories, options)
+}
+
+func containsString(values []string, value string) (bool) {
+  for _, v := range values {
+    if v == value {
+      return true
+    }
+  }
+  return false
+}
+
+func matchesCategories(publicationCategories []string, options QueryOptions) (bool) {
+  categories := uniqueStrings(options.Categories)
+  if len(categories) == 0 {
+    return true
+  }
+
+  tagged := make(map[string]bool)
+  for _, category := range publicationCategories {
+    tagged[category] = true
+  }
+
+  matched := 0
+  for _, category := range categories {
+    if tagged[category] {
+      matched++
+    }
+  }
+
+  if options.MatchAllCategories {
+    return matched == len(categories)
+  }
+  return matched > 0
+}
+
+/*
+matchingParagraphs returns the indices of the paragraphs which contain every
+one of the tokens, in the order in which the paragraphs were added.
+*/
+func (m *memoryIndex) matchingParagraphs(tokens []string) ([]int) {
+  if len(tokens) == 0 {
+    return []int{}
+  }
+
+  matches := m.postings[tokens[0]]
+  for _, token := range tokens[1:] {
+    matches = intersectPostings(matches, m.postings[token])
+  }
+
+  return matches
+}
+
+func intersectPostings(a, b []int) ([]int) {
+  result := make([]int, 0)
+  i, j := 0, 0
+  for i < len(a) && j < len(b) {
+    if a[i] == b[j] {
+      result = append(result, a[i])
+      i++
+      j++
+    } else if a[i] < b[j] {
+      i++
+    } else {
+      j++
+    }
+  }
+
+  return result
+}
+
+/*
+searchTokens splits text in the given language into the normalized tokens that
+are kept in the inverted index. Only English words are stemmed, and words in
+other languages are only lower-cased.
+*/
+func searchTokens(text, language string) ([]string) {
+  words := SplitWords(text)
+  tokens := make([]string, len(words))
+  for i, word := range words {
+    tokens[i] = CanonicalWordForm(word)
+    if language == DefaultLanguage {
+      tokens[i] = porterstemmer.StemString(tokens[i])
+    }
+  }
+
+  return tokens
+}
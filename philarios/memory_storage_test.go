@@ -0,0 +1,766 @@
+package philarios
+
+import (
+  "context"
+  "errors"
+  "reflect"
+  "testing"
+)
+
+func setupMemoryStorage() (Storage, error) {
+  storage := NewMemoryStorage()
+
+  publications := []Publication{
+    {
+      Title: "Great Expectations",
+      Author: "Charles Dickens",
+      Date: "1860-01-12",
+      SourceID: "great-expectations",
+      Encoding: "utf-8",
+      Type: "book",
+      Categories: []string{"classic", "dickens"},
+      Text: `Ours was the marsh country, down by the river, within, as the river wound, twenty miles of the sea. At such a time I found out for certain that Philip Pirrip, late of this parish, and also Georgiana wife of the above, were dead and buried.`,
+    },
+    {
+      Title: "Great Expectations (tombstone)",
+      Author: "Charles Dickens",
+      Date: "1860-01-12",
+      SourceID: "great-expectations-tombstone",
+      Encoding: "utf-8",
+      Type: "book",
+      Categories: []string{"classic", "dickens"},
+      Text: `From the character and turn of the inscription, "Also Georgiana Wife of the Above," I drew a childish conclusion that my mother was freckled and sickly.`,
+    },
+    {
+      Title: "Pip",
+      Author: "Charles Dickens",
+      Date: "1860-01-12",
+      SourceID: "pip",
+      Encoding: "utf-8",
+      Type: "book",
+      Categories: []string{"classic"},
+      Text: `"Tell us your name!" said the man. "Quick!"`,
+    },
+  }
+
+  for _, publication := range publications {
+    err := storage.AddPublication(publication)
+    if err != nil {
+      return storage, err
+    }
+  }
+
+  return storage, nil
+}
+
+func TestCreatingAndQueryingMemoryStorageWithoutCategories(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    ExpectedPublicationIds []int
+  }{
+    {"Georgiana", []int{1, 2}},
+    {"georgiana", []int{1, 2}},
+    {"river", []int{1}},
+    {"name", []int{3}},
+    {"nonexistent", []int{}},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWord(fixture.Word, nil)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != len(fixture.ExpectedPublicationIds) {
+      t.Errorf("Should have obtained %d paragraphs for %q, instead obtained %d",
+        len(fixture.ExpectedPublicationIds), fixture.Word, len(paragraphs))
+      continue
+    }
+
+    for i, paragraph := range paragraphs {
+      if paragraph.PublicationId != fixture.ExpectedPublicationIds[i] {
+        t.Errorf("Should have obtained paragraph with PublicationId %d, instead obtained %d",
+          fixture.ExpectedPublicationIds[i], paragraph.PublicationId)
+      }
+    }
+  }
+}
+
+func TestAddingDuplicateSourceIDToMemoryStorage(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  err = storage.AddPublication(Publication{
+    SourceID: "pip",
+    Text: "Georgiana",
+  })
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a duplicate publication: %s", err.Error())
+  }
+
+  paragraphs, err := storage.QueryForWord("Georgiana", nil)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+
+  expectedParagraphs := 2
+  if len(paragraphs) != expectedParagraphs {
+    t.Errorf("Should have obtained %d paragraphs, instead obtained %d", expectedParagraphs, len(paragraphs))
+  }
+}
+
+func TestQueryingMemoryStorageWithCategories(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    ExpectedParagraphs int
+  }{
+    {"Georgiana", QueryOptions{Categories: []string{"dickens"}}, 2},
+    {"Georgiana", QueryOptions{Categories: []string{"wikipedia_article"}}, 0},
+    {"Georgiana", QueryOptions{Categories: []string{"wikipedia_article", "classic"}}, 2},
+    {"Georgiana", QueryOptions{Categories: []string{"wikipedia_article", "classic"}, MatchAllCategories: true}, 0},
+    {"name", QueryOptions{Categories: []string{"classic", "dickens"}, MatchAllCategories: true}, 0},
+    {"name", QueryOptions{Categories: []string{"classic", "classic"}, MatchAllCategories: true}, 1},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWordWithOptions(fixture.Word, fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q with options %+v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Options, len(paragraphs))
+    }
+  }
+}
+
+func TestUpsertingPublicationInMemoryStorage(t *testing.T) {
+  storage := NewMemoryStorage()
+  publication := Publication{
+    SourceID: "pip",
+    Categories: []string{"classic"},
+    Text: "Pip, sir.",
+  }
+
+  err := storage.UpsertPublication(publication)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when upserting a publication: %s", err.Error())
+  }
+
+  publication.Categories = []string{"dickens"}
+  publication.Text = "Once more, said the man."
+  err = storage.UpsertPublication(publication)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when replacing a publication: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    ExpectedParagraphs int
+  }{
+    {"Pip", QueryOptions{}, 0},
+    {"man", QueryOptions{}, 1},
+    {"man", QueryOptions{Categories: []string{"classic"}}, 0},
+    {"man", QueryOptions{Categories: []string{"dickens"}}, 1},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWordWithOptions(fixture.Word, fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q with options %+v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Options, len(paragraphs))
+    }
+
+    for _, paragraph := range paragraphs {
+      if paragraph.PublicationId != 1 {
+        t.Errorf("Replacing a publication should have kept its id, instead obtained %d",
+          paragraph.PublicationId)
+      }
+    }
+  }
+}
+
+func TestRemovingAndReplacingPublicationsInMemoryStorage(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  publicationId, err := storage.RemovePublication("great-expectations-tombstone")
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when removing a publication: %s", err.Error())
+  }
+  if publicationId != 2 {
+    t.Errorf("Should have removed the publication with id 2, instead removed %d", publicationId)
+  }
+
+  _, err = storage.RemovePublication("great-expectations-tombstone")
+  if err != ErrPublicationNotFound {
+    t.Errorf("Should have obtained ErrPublicationNotFound when removing twice, instead obtained %v", err)
+  }
+
+  _, err = storage.ReplacePublication(Publication{SourceID: "nonexistent", Text: "Georgiana"})
+  if err != ErrPublicationNotFound {
+    t.Errorf("Should have obtained ErrPublicationNotFound when replacing a missing publication, instead obtained %v", err)
+  }
+
+  publicationId, err = storage.ReplacePublication(Publication{
+    SourceID: "pip",
+    Categories: []string{"classic"},
+    Text: "Georgiana, said the man.",
+  })
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when replacing a publication: %s", err.Error())
+  }
+  if publicationId != 3 {
+    t.Errorf("Should have replaced the publication with id 3, instead replaced %d", publicationId)
+  }
+
+  fixtures := []struct {
+    Word string
+    ExpectedPublicationIds []int
+  }{
+    {"Georgiana", []int{1, 3}},
+    {"inscription", []int{}},
+    {"name", []int{}},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWord(fixture.Word, nil)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != len(fixture.ExpectedPublicationIds) {
+      t.Errorf("Should have obtained %d paragraphs for %q, instead obtained %d",
+        len(fixture.ExpectedPublicationIds), fixture.Word, len(paragraphs))
+      continue
+    }
+
+    for i, paragraph := range paragraphs {
+      if paragraph.PublicationId != fixture.ExpectedPublicationIds[i] {
+        t.Errorf("Should have obtained paragraph with PublicationId %d, instead obtained %d",
+          fixture.ExpectedPublicationIds[i], paragraph.PublicationId)
+      }
+    }
+  }
+}
+
+func TestIteratingOverParagraphsInMemoryStorage(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    StopAfter int
+    ExpectedParagraphs int
+  }{
+    {"Georgiana", QueryOptions{}, 0, 2},
+    {"Georgiana", QueryOptions{Limit: 1}, 0, 1},
+    {"Georgiana", QueryOptions{Limit: 5}, 0, 2},
+    {"Georgiana", QueryOptions{}, 1, 1},
+    {"Georgiana", QueryOptions{Categories: []string{"dickens"}, Limit: 1}, 0, 1},
+  }
+
+  for _, fixture := range fixtures {
+    handled := 0
+    err := storage.EachParagraphForWord(fixture.Word, fixture.Options, func(paragraph Paragraph) (error) {
+      handled++
+      if handled == fixture.StopAfter {
+        return ErrStopIteration
+      }
+      return nil
+    })
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when iterating over paragraphs: %s", err.Error())
+    }
+
+    if handled != fixture.ExpectedParagraphs {
+      t.Errorf("Should have handled %d paragraphs for %q with options %+v, instead handled %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Options, handled)
+    }
+  }
+
+  handlerErr := errors.New("handler failed")
+  err = storage.EachParagraphForWord("Georgiana", QueryOptions{}, func(paragraph Paragraph) (error) {
+    return handlerErr
+  })
+  if err != handlerErr {
+    t.Errorf("Should have obtained the handler's error, instead obtained %v", err)
+  }
+}
+
+func TestRankingParagraphsInMemoryStorage(t *testing.T) {
+  storage := NewMemoryStorage()
+  texts := []string{
+    "The river ran past the marsh country and the churchyard where they were buried.",
+    "River, river, river.",
+    "Down by the river.",
+  }
+  for i, text := range texts {
+    err := storage.AddPublication(Publication{SourceID: string(rune('a' + i)), Text: text})
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+    }
+  }
+
+  paragraphs, err := storage.QueryForWordWithOptions("river", QueryOptions{Ranked: true, Limit: 2})
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+
+  expectedPublicationIds := []int{2, 3}
+  if len(paragraphs) != len(expectedPublicationIds) {
+    t.Errorf("Should have obtained %d paragraphs, instead obtained %d", len(expectedPublicationIds), len(paragraphs))
+    return
+  }
+
+  for i, paragraph := range paragraphs {
+    if paragraph.PublicationId != expectedPublicationIds[i] {
+      t.Errorf("Should have obtained paragraph with PublicationId %d at rank %d, instead obtained %d",
+        expectedPublicationIds[i], i, paragraph.PublicationId)
+    }
+    if paragraph.Score <= 0 {
+      t.Errorf("Should have obtained a positive score for a matching paragraph, instead obtained %v", paragraph.Score)
+    }
+  }
+
+  if paragraphs[0].Score < paragraphs[1].Score {
+    t.Errorf("Paragraphs should be in decreasing order of score, obtained %v and %v",
+      paragraphs[0].Score, paragraphs[1].Score)
+  }
+}
+
+func TestQueryingMemoryStorageByLanguage(t *testing.T) {
+  storage := NewMemoryStorage()
+  publications := []Publication{
+    {SourceID: "english", Text: "The river wound past the marshes."},
+    {SourceID: "french", Language: "french", Text: "La rivière passait près des marais, et le river-boat aussi."},
+    {SourceID: "german", Language: "German", Text: "Der Fluss floss an den Sümpfen vorbei."},
+  }
+  for _, publication := range publications {
+    err := storage.AddPublication(publication)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+    }
+  }
+
+  fixtures := []struct {
+    Word string
+    Language string
+    ExpectedPublicationIds []int
+  }{
+    {"river", "", []int{1}},
+    {"river", "english", []int{1}},
+    {"river", "french", []int{2}},
+    {"rivière", "french", []int{2}},
+    {"rivière", "", []int{}},
+    {"Sümpfen", "german", []int{3}},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWordWithOptions(fixture.Word, QueryOptions{Language: fixture.Language})
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != len(fixture.ExpectedPublicationIds) {
+      t.Errorf("Should have obtained %d paragraphs for %q in %q, instead obtained %d",
+        len(fixture.ExpectedPublicationIds), fixture.Word, fixture.Language, len(paragraphs))
+      continue
+    }
+
+    for i, paragraph := range paragraphs {
+      if paragraph.PublicationId != fixture.ExpectedPublicationIds[i] {
+        t.Errorf("Should have obtained paragraph with PublicationId %d, instead obtained %d",
+          fixture.ExpectedPublicationIds[i], paragraph.PublicationId)
+      }
+    }
+  }
+}
+
+func TestQueryingMemoryStorageForPhrases(t *testing.T) {
+  storage := NewMemoryStorage()
+  texts := []string{
+    "In spite of the weather, he went out. In spite of everything.",
+    "The spite of the man was plain, in the end.",
+    "Take care of the boy, and take care of yourself.",
+  }
+  for i, text := range texts {
+    err := storage.AddPublication(Publication{SourceID: string(rune('a' + i)), Text: text})
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+    }
+  }
+
+  fixtures := []struct {
+    Words []string
+    ExpectedPublicationIds []int
+  }{
+    {[]string{"in", "spite", "of"}, []int{1}},
+    {[]string{"spite", "of"}, []int{1, 2}},
+    {[]string{"Take", "care"}, []int{3}},
+    {[]string{"care", "take"}, []int{}},
+    {[]string{}, []int{}},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForPhrase(fixture.Words, nil)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for phrase: %s", err.Error())
+    }
+
+    if len(paragraphs) != len(fixture.ExpectedPublicationIds) {
+      t.Errorf("Should have obtained %d paragraphs for %v, instead obtained %d",
+        len(fixture.ExpectedPublicationIds), fixture.Words, len(paragraphs))
+      continue
+    }
+
+    for i, paragraph := range paragraphs {
+      if paragraph.PublicationId != fixture.ExpectedPublicationIds[i] {
+        t.Errorf("Should have obtained paragraph with PublicationId %d, instead obtained %d",
+          fixture.ExpectedPublicationIds[i], paragraph.PublicationId)
+      }
+    }
+  }
+}
+
+func TestNeighboringParagraphsInMemoryStorage(t *testing.T) {
+  storage := NewMemoryStorage()
+  text := "Pip, sir.\nOnce more, said the man.\n\n  Give it mouth!\nPip. Pip, sir."
+  err := storage.AddPublication(Publication{SourceID: "pip", Text: text})
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  matches, err := storage.QueryForWord("mouth", nil)
+  if err != nil || len(matches) != 1 {
+    t.Errorf("Should have obtained a single paragraph for 'mouth', instead obtained %v (err=%v)", matches, err)
+    return
+  }
+
+  match := matches[0]
+  if match.Position != 2 {
+    t.Errorf("Should have obtained the paragraph at position 2, instead obtained %d", match.Position)
+  }
+  if text[match.StartOffset:match.EndOffset] != match.Body {
+    t.Errorf("Paragraph offsets [%d, %d) do not point at its body '%s'", match.StartOffset, match.EndOffset, match.Body)
+  }
+
+  fixtures := []struct {
+    Radius int
+    ExpectedPositions []int
+  }{
+    {0, []int{2}},
+    {1, []int{1, 2, 3}},
+    {5, []int{0, 1, 2, 3}},
+  }
+
+  for _, fixture := range fixtures {
+    neighbors, err := storage.NeighboringParagraphs(match.ID, fixture.Radius)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when obtaining neighboring paragraphs: %s", err.Error())
+    }
+
+    if len(neighbors) != len(fixture.ExpectedPositions) {
+      t.Errorf("Should have obtained %d neighbors with radius %d, instead obtained %d",
+        len(fixture.ExpectedPositions), fixture.Radius, len(neighbors))
+      continue
+    }
+
+    for i, neighbor := range neighbors {
+      if neighbor.Position != fixture.ExpectedPositions[i] {
+        t.Errorf("Should have obtained the paragraph at position %d, instead obtained %d",
+          fixture.ExpectedPositions[i], neighbor.Position)
+      }
+    }
+  }
+
+  _, err = storage.NeighboringParagraphs(100, 1)
+  if err != ErrParagraphNotFound {
+    t.Errorf("Should have obtained ErrParagraphNotFound for a missing paragraph, instead obtained %v", err)
+  }
+
+  _, err = storage.RemovePublication("pip")
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when removing a publication: %s", err.Error())
+  }
+  _, err = storage.NeighboringParagraphs(match.ID, 1)
+  if err != ErrParagraphNotFound {
+    t.Errorf("Should have obtained ErrParagraphNotFound for a removed paragraph, instead obtained %v", err)
+  }
+}
+
+func TestPublicationCatalogInMemoryStorage(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  err = storage.AddPublication(Publication{
+    Title: "Name",
+    Author: "wikipedia",
+    Date: "2014-08-01",
+    SourceID: "12",
+    Type: "wikipedia_article",
+    Text: "A name is a term used for identification.",
+  })
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  publication, err := storage.GetPublicationBySourceID("12")
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when getting a publication: %s", err.Error())
+  }
+  if publication.ID != 4 || publication.Title != "Name" || publication.Language != DefaultLanguage {
+    t.Errorf("Obtained an unexpected publication: %+v", publication)
+  }
+
+  publication, err = storage.GetPublication(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when getting a publication: %s", err.Error())
+  }
+  if publication.SourceID != "great-expectations" || len(publication.Categories) != 2 {
+    t.Errorf("Obtained an unexpected publication: %+v", publication)
+  }
+
+  _, err = storage.GetPublication(100)
+  if err != ErrPublicationNotFound {
+    t.Errorf("Should have obtained ErrPublicationNotFound for a missing publication, instead obtained %v", err)
+  }
+
+  fixtures := []struct {
+    Filter PublicationFilter
+    ExpectedIds []int
+  }{
+    {PublicationFilter{}, []int{1, 2, 3, 4}},
+    {PublicationFilter{Author: "Charles Dickens"}, []int{1, 2, 3}},
+    {PublicationFilter{Type: "wikipedia_article"}, []int{4}},
+    {PublicationFilter{Category: "dickens"}, []int{1, 2}},
+    {PublicationFilter{StartDate: "1900-01-01"}, []int{4}},
+    {PublicationFilter{EndDate: "1860-01-12"}, []int{1, 2, 3}},
+    {PublicationFilter{StartDate: "1800-01-01", EndDate: "1859-12-31"}, []int{}},
+    {PublicationFilter{Offset: 1, Limit: 2}, []int{2, 3}},
+    {PublicationFilter{Author: "Charles Dickens", Offset: 2, Limit: 5}, []int{3}},
+    {PublicationFilter{AfterID: 2}, []int{3, 4}},
+    {PublicationFilter{AfterID: 1, Limit: 2}, []int{2, 3}},
+    {PublicationFilter{Author: "Charles Dickens", AfterID: 2}, []int{3}},
+  }
+
+  for _, fixture := range fixtures {
+    publications, err := storage.ListPublications(fixture.Filter)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when listing publications: %s", err.Error())
+    }
+
+    ids := make([]int, len(publications))
+    for i, publication := range publications {
+      ids[i] = publication.ID
+    }
+    if !reflect.DeepEqual(ids, fixture.ExpectedIds) {
+      t.Errorf("Should have listed publications %v with filter %+v, instead listed %v",
+        fixture.ExpectedIds, fixture.Filter, ids)
+    }
+  }
+}
+
+func TestDeduplicatingParagraphsInMemoryStorage(t *testing.T) {
+  storage := NewMemoryStorage()
+  publications := []Publication{
+    {SourceID: "a", Categories: []string{"first"}, Text: "Pip, sir.\nOnce more, said the man."},
+    {SourceID: "b", Categories: []string{"second"}, Text: "\"Pip, sir!\"\nGive it mouth, said the man."},
+  }
+  for _, publication := range publications {
+    err := storage.AddPublication(publication)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+    }
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    ExpectedIds []int
+  }{
+    {"pip", QueryOptions{}, []int{1}},
+    {"pip", QueryOptions{IncludeDuplicates: true}, []int{1, 3}},
+    {"pip", QueryOptions{Categories: []string{"second"}}, []int{3}},
+    {"man", QueryOptions{}, []int{2, 4}},
+    {"pip", QueryOptions{Ranked: true, Limit: 1}, []int{1}},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWordWithOptions(fixture.Word, fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    ids := make([]int, len(paragraphs))
+    for i, paragraph := range paragraphs {
+      ids[i] = paragraph.ID
+    }
+    if !reflect.DeepEqual(ids, fixture.ExpectedIds) {
+      t.Errorf("Should have obtained paragraphs %v for %q with options %+v, instead obtained %v",
+        fixture.ExpectedIds, fixture.Word, fixture.Options, ids)
+    }
+  }
+
+  _, err := storage.RemovePublication("a")
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when removing a publication: %s", err.Error())
+  }
+  paragraphs, _ := storage.QueryForWord("pip", nil)
+  if len(paragraphs) != 1 || paragraphs[0].ID != 3 {
+    t.Errorf("Should have obtained the remaining duplicate once its original was removed, instead obtained %v", paragraphs)
+  }
+}
+
+func TestFilteringDuplicateParagraphsInMemoryStorage(t *testing.T) {
+  storage := NewMemoryStorage()
+  publications := []Publication{
+    {SourceID: "quotation", Author: "wikipedia", Date: "2014-08-01",
+      Text: "It was the best of times, it was the worst of times."},
+    {SourceID: "two-cities", Author: "Charles Dickens", Date: "1859-04-30",
+      Text: "It was the best of times, it was the worst of times."},
+  }
+  for _, publication := range publications {
+    err := storage.AddPublication(publication)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+    }
+  }
+
+  fixtures := []struct {
+    Options QueryOptions
+    ExpectedIds []int
+  }{
+    {QueryOptions{}, []int{1}},
+    {QueryOptions{Authors: []string{"Charles Dickens"}}, []int{2}},
+    {QueryOptions{StartDate: "1800-01-01", EndDate: "1900-12-31"}, []int{2}},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWordWithOptions("times", fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    ids := make([]int, len(paragraphs))
+    for i, paragraph := range paragraphs {
+      ids[i] = paragraph.ID
+    }
+    if !reflect.DeepEqual(ids, fixture.ExpectedIds) {
+      t.Errorf("Should have obtained paragraphs %v with options %+v, instead obtained %v",
+        fixture.ExpectedIds, fixture.Options, ids)
+    }
+  }
+}
+
+func TestQueryingMemoryStorageByAuthorAndDate(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  err = storage.AddPublication(Publication{
+    Title: "River",
+    Author: "wikipedia",
+    Date: "2014-08-01",
+    SourceID: "river",
+    Type: "wikipedia_article",
+    Text: "A river is a natural flowing watercourse, which flows towards the sea.",
+  })
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    ExpectedParagraphs int
+  }{
+    {"sea", QueryOptions{}, 2},
+    {"sea", QueryOptions{Authors: []string{"Charles Dickens"}}, 1},
+    {"sea", QueryOptions{Authors: []string{"Charles Dickens", "wikipedia"}}, 2},
+    {"sea", QueryOptions{Authors: []string{"Dickens"}}, 0},
+    {"sea", QueryOptions{StartDate: "1800-01-01", EndDate: "1900-12-31"}, 1},
+    {"sea", QueryOptions{StartDate: "1860-01-12"}, 2},
+    {"sea", QueryOptions{EndDate: "1860-01-11"}, 0},
+    {"sea", QueryOptions{Authors: []string{"wikipedia"}, EndDate: "1900-12-31"}, 0},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWordWithOptions(fixture.Word, fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q with options %+v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Options, len(paragraphs))
+    }
+  }
+}
+
+func TestCancellingMemoryStorageCalls(t *testing.T) {
+  storage, err := setupMemoryStorage()
+  if err != nil {
+    t.Errorf("Error setting up memory storage and seeding with data: %s", err.Error())
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  bound := storage.WithContext(ctx)
+
+  handled := 0
+  err = bound.EachParagraphForWord("georgiana", QueryOptions{}, func(paragraph Paragraph) (error) {
+    handled++
+    cancel()
+    return nil
+  })
+  if err != context.Canceled || handled != 1 {
+    t.Errorf("Should have stopped with context.Canceled after 1 paragraph, instead obtained %v after %d", err, handled)
+  }
+
+  _, err = bound.QueryForWord("river", nil)
+  if err != context.Canceled {
+    t.Errorf("Should have obtained context.Canceled when querying, instead obtained %v", err)
+  }
+
+  err = bound.AddPublication(Publication{SourceID: "cancelled", Text: "Pip, sir."})
+  if err != context.Canceled {
+    t.Errorf("Should have obtained context.Canceled when adding a publication, instead obtained %v", err)
+  }
+
+  // The storage it was bound from is unaffected by the cancellation.
+  paragraphs, err := storage.QueryForWord("river", nil)
+  if err != nil || len(paragraphs) != 1 {
+    t.Errorf("Should have obtained 1 paragraph without a context, instead obtained %d (err=%v)", len(paragraphs), err)
+  }
+  _, err = storage.GetPublicationBySourceID("cancelled")
+  if err != ErrPublicationNotFound {
+    t.Errorf("Publication shouldn't have been added once the context was cancelled, instead obtained %v", err)
+  }
+}
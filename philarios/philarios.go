@@ -1,6 +1,8 @@
 package philarios
 
 import (
+  "context"
+
   "github.com/wangjohn/quickselect"
   "github.com/wangjohn/updike/tfidf"
 )
@@ -34,6 +36,21 @@ type WordFactory struct {
   TFIDF tfidf.TFIDF
 }
 
+/*
+WithContext returns a copy of the factory whose Storage and TFIDF are bound to
+ctx, so that finding alternative words is abandoned with an error once ctx is
+cancelled or its deadline passes.
+*/
+func (p WordFactory) WithContext(ctx context.Context) (WordFactory) {
+  if p.Storage != nil {
+    p.Storage = p.Storage.WithContext(ctx)
+  }
+  if p.TFIDF != nil {
+    p.TFIDF = p.TFIDF.WithContext(ctx)
+  }
+  return p
+}
+
 type Philarios interface {
   SentenceFindAlternativeWords(sentence string, queryStart, queryEnd, maxWords int) ([]string, error)
   FindAlternativeWords(beforeWords, afterWords []string, queryWord string, maxWords int) ([]string, error)
@@ -69,11 +86,8 @@ func (p WordFactory) FindAlternativeWords(beforeWords, afterWords []string, quer
   beforeWords = p.findImportantWords(beforeWords)
   afterWords = p.findImportantWords(afterWords)
 
-  // TODO: figure out how we want to include categories. This is a stop gap.
-  categories := []string{}
-
   for _, beforeWord := range beforeWords {
-    _, err := p.Storage.QueryForWord(beforeWord, categories)
+    _, err := p.Storage.QueryForWordWithOptions(beforeWord, p.queryOptions())
     if err != nil {
       return alternativeWords, err
     }
@@ -85,6 +99,23 @@ func (p WordFactory) FindAlternativeWords(beforeWords, afterWords []string, quer
   return alternativeWords, nil
 }
 
+/*
+queryOptions returns the options used when querying storage for the contexts
+of words, as specified by the factory's settings.
+*/
+func (p WordFactory) queryOptions() (QueryOptions) {
+  return QueryOptions{
+    Categories: p.Settings.Categories,
+    MatchAllCategories: p.Settings.MatchAllCategories,
+    Limit: p.Settings.MaxParagraphs,
+    Ranked: p.Settings.RankParagraphs,
+    Language: p.Settings.Language,
+    Authors: p.Settings.Authors,
+    StartDate: p.Settings.StartDate,
+    EndDate: p.Settings.EndDate,
+  }
+}
+
 func (p WordFactory) findImportantWords(words []string) ([]string) {
   // TODO: for now, returning all words. In the future, we probably want to filter
   // by words with high aggregate TFIDF scores.
@@ -148,6 +179,53 @@ func (p WordFactory) AlternativeWordVectors(word string, maxWords int) ([]WordVe
   return wordVectors[wordsToSelect:], nil
 }
 
+/*
+IndexPublication stores the words of every paragraph of the publication with
+the given id as its document in the TFIDF, replacing the words stored for it
+before.
+*/
+func (p WordFactory) IndexPublication(publicationId int) (error) {
+  paragraphs, err := p.Storage.PublicationParagraphs(publicationId)
+  if err != nil {
+    return err
+  }
+
+  words := make([]string, 0)
+  for _, paragraph := range paragraphs {
+    words = append(words, SplitWords(paragraph.Body)...)
+  }
+
+  return p.TFIDF.StoreDocument(publicationId, words)
+}
+
+/*
+RemovePublication removes the publication with the given SourceID from
+storage, along with its document in the TFIDF, so that it no longer
+contributes to the words that are suggested.
+*/
+func (p WordFactory) RemovePublication(sourceID string) (error) {
+  publicationId, err := p.Storage.RemovePublication(sourceID)
+  if err != nil {
+    return err
+  }
+
+  return p.TFIDF.RemoveDocument(publicationId)
+}
+
+/*
+ReplacePublication replaces the contents of the publication with the same
+SourceID in storage, and reindexes the publication so that its document in the
+TFIDF describes the new text rather than the replaced one.
+*/
+func (p WordFactory) ReplacePublication(publication Publication) (error) {
+  publicationId, err := p.Storage.ReplacePublication(publication)
+  if err != nil {
+    return err
+  }
+
+  return p.IndexPublication(publicationId)
+}
+
 /*
 The synonymScore method specifies the mapping between a word's score and its
 rank relative to other words. This function allows finer control over the words
@@ -157,22 +235,43 @@ func synonymScore(score float64) (float64) {
   return score
 }
 
+/*
+TargetVectors returns the words found around the given word in storage, scored
+by how often they occur around it. The word may also be a multi-word
+expression such as "in spite of", in which case the words around the whole
+expression are used. Paragraphs are consumed from storage one at a time, and at
+most Settings.MaxParagraphs of them are used, the most relevant first if
+Settings.RankParagraphs is set.
+*/
 func (p WordFactory) TargetVectors(word string) ([]WordVector, error) {
-  paragraphs, err := p.Storage.QueryForWord(word, nil)
-  if err != nil {
-    return nil, err
-  }
-
+  phrase := SplitWords(word)
   scoreCollection := make(map[string]float64)
-  for _, paragraph := range paragraphs {
-    probWordVectors, err := p.associatedWordProbabilities(paragraph.Body, word)
+  paragraphCount := 0
+  handler := func(paragraph Paragraph) (error) {
+    probWordVectors, err := p.associatedWordProbabilities(paragraph.Body, phrase)
     if err != nil {
-      return nil, err
+      return err
     }
 
     for _, vec := range probWordVectors {
-      scoreCollection[vec.Word] += (vec.Score / float64(len(paragraphs)))
+      scoreCollection[vec.Word] += vec.Score
     }
+    paragraphCount++
+    return nil
+  }
+
+  var err error
+  if len(phrase) > 1 {
+    err = p.Storage.EachParagraphForPhrase(phrase, p.queryOptions(), handler)
+  } else {
+    err = p.Storage.EachParagraphForWord(word, p.queryOptions(), handler)
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  for key := range scoreCollection {
+    scoreCollection[key] /= float64(paragraphCount)
   }
 
   var wordVectors = make([]WordVector, len(scoreCollection))
@@ -184,25 +283,25 @@ func (p WordFactory) TargetVectors(word string) ([]WordVector, error) {
   return wordVectors, nil
 }
 
-func (p WordFactory) associatedWordProbabilities(paragraph, word string) ([]WordVector, error) {
+func (p WordFactory) associatedWordProbabilities(paragraph string, phrase []string) ([]WordVector, error) {
   associatedCounts := make(map[string]int)
 
   paragraphWords := SplitWords(paragraph)
-  wordOccurrences := 0
-  for i, pw := range paragraphWords {
-    if FuzzyStringEquals(pw, word) {
-      for _, surroundingWord := range p.surroundingWords(paragraphWords, i) {
+  phraseOccurrences := 0
+  for i := 0; i + len(phrase) <= len(paragraphWords) && len(phrase) > 0; i++ {
+    if phraseMatches(paragraphWords[i:i + len(phrase)], phrase) {
+      for _, surroundingWord := range p.surroundingWords(paragraphWords, i, i + len(phrase)) {
         canonicalSW := CanonicalWordForm(surroundingWord)
         associatedCounts[canonicalSW]++
       }
-      wordOccurrences++
+      phraseOccurrences++
     }
   }
 
   wordVectors := make([]WordVector, len(associatedCounts))
   i := 0
   for key, value := range associatedCounts {
-    occurrenceProb := float64(value) / float64(wordOccurrences)
+    occurrenceProb := float64(value) / float64(phraseOccurrences)
     wordVectors[i] = WordVector{key, occurrenceProb}
     i++
   }
@@ -210,27 +309,39 @@ func (p WordFactory) associatedWordProbabilities(paragraph, word string) ([]Word
   return wordVectors, nil
 }
 
-func (p WordFactory) surroundingWords(words []string, wordIndex int) ([]string) {
+func phraseMatches(words, phrase []string) (bool) {
+  for i := range phrase {
+    if !FuzzyStringEquals(words[i], phrase[i]) {
+      return false
+    }
+  }
+  return true
+}
+
+/*
+surroundingWords returns the words captured around the phrase which starts at
+phraseStart and ends before phraseEnd, excluding the phrase itself.
+*/
+func (p WordFactory) surroundingWords(words []string, phraseStart, phraseEnd int) ([]string) {
+  lastIndex := phraseEnd - 1
+
   var start, end int
-  if wordIndex > p.Settings.WordsToCapture {
-    start = wordIndex - p.Settings.WordsToCapture
+  if phraseStart > p.Settings.WordsToCapture {
+    start = phraseStart - p.Settings.WordsToCapture
   } else {
     start = 0
   }
 
-  if p.Settings.WordsToCapture + wordIndex < len(words) {
-    end = wordIndex + p.Settings.WordsToCapture
+  if p.Settings.WordsToCapture + lastIndex < len(words) {
+    end = lastIndex + p.Settings.WordsToCapture
   } else {
     end = len(words)
   }
 
-  surrounding := make([]string, end - start - 1)
-
-  j := 0
+  surrounding := make([]string, 0, end - start)
   for i := start; i < end; i++ {
-    if i != wordIndex {
-      surrounding[j] = words[i]
-      j++
+    if i < phraseStart || i > lastIndex {
+      surrounding = append(surrounding, words[i])
     }
   }
 
@@ -1,34 +1,15 @@
 package philarios
 
 import (
-  "database/sql"
   "testing"
 
   "github.com/wangjohn/updike/tfidf"
 )
 
-const (
-  tfidfDriverName = "postgres"
-  tfidfDataSourceName = "host=localhost user=philarios dbname=philarios_tfidf_test sslmode=disable"
-
-  storageDriverName = "postgres"
-  storageDataSourceName = "host=localhost user=philarios dbname=philarios_storage_test sslmode=disable"
-)
-
 func setupWordFactory() (*WordFactory, error) {
-  storageDb, err := sql.Open(storageDriverName, storageDataSourceName)
-  if err != nil {
-    return nil, err
-  }
-
-  storage := PostgresStorage{storageDb}
+  storage := NewMemoryStorage()
   settings := DefaultSettingsObject()
-  tfidfDb, err := sql.Open(tfidfDriverName, tfidfDataSourceName)
-
-  if err != nil {
-    return nil, err
-  }
-  tfidf := tfidf.PersistentTFIDF{tfidfDb}
+  tfidf := tfidf.NewMemoryTFIDF()
   storage.AddPublication(Publication{
     Title: "Great Expectations",
     Author: "Charles Dickens",
@@ -78,3 +59,117 @@ func TestAlternativeWords(t *testing.T) {
     t.Errorf("Alternative words: %v", alternatives)
   }
 }
+
+func TestTargetVectorsForPhrase(t *testing.T) {
+  storage := NewMemoryStorage()
+  err := storage.AddPublication(Publication{
+    SourceID: "care",
+    Text: "Please take care of the boy.",
+  })
+  if err != nil {
+    t.Errorf("Error adding publication: %v", err)
+  }
+
+  wordFactory := WordFactory{Storage: storage, Settings: DefaultSettingsObject()}
+  vectors, err := wordFactory.TargetVectors("take care")
+  if err != nil {
+    t.Errorf("Error obtaining target vectors: %v", err)
+  }
+
+  expectedWords := map[string]bool{"please": true, "of": true}
+  if len(vectors) != len(expectedWords) {
+    t.Errorf("Expected %d target vectors, obtained %v", len(expectedWords), vectors)
+  }
+  for _, vector := range vectors {
+    if !expectedWords[vector.Word] {
+      t.Errorf("Did not expect word %q around the phrase, obtained %v", vector.Word, vectors)
+    }
+  }
+}
+
+func TestIndexPublication(t *testing.T) {
+  storage := NewMemoryStorage()
+  err := storage.AddPublication(Publication{
+    SourceID: "care",
+    Text: "Please take care of the boy.\nTake the boy home.",
+  })
+  if err != nil {
+    t.Errorf("Error adding publication: %v", err)
+  }
+  publication, err := storage.GetPublicationBySourceID("care")
+  if err != nil {
+    t.Errorf("Error obtaining publication: %v", err)
+  }
+
+  wordFactory := WordFactory{Storage: storage, Settings: DefaultSettingsObject(), TFIDF: tfidf.NewMemoryTFIDF()}
+  err = wordFactory.IndexPublication(publication.ID)
+  if err != nil {
+    t.Errorf("Error indexing publication: %v", err)
+  }
+
+  fixtures := []struct {
+    Word string
+    ExpectedTF float64
+  }{
+    {"boy", 1.0},
+    {"please", 0.75},
+    {"nowhere", 0.5},
+  }
+
+  for _, fixture := range fixtures {
+    tf, err := wordFactory.TFIDF.TermFrequency(fixture.Word, publication.ID)
+    if err != nil {
+      t.Errorf("Error obtaining term frequency: %v", err)
+    }
+    if tf != fixture.ExpectedTF {
+      t.Errorf("Expected a term frequency of %v for %q, obtained %v", fixture.ExpectedTF, fixture.Word, tf)
+    }
+  }
+}
+
+func TestReplacePublicationReindexes(t *testing.T) {
+  storage := NewMemoryStorage()
+  err := storage.AddPublication(Publication{
+    SourceID: "care",
+    Text: "Please take care of the boy.",
+  })
+  if err != nil {
+    t.Errorf("Error adding publication: %v", err)
+  }
+  publication, err := storage.GetPublicationBySourceID("care")
+  if err != nil {
+    t.Errorf("Error obtaining publication: %v", err)
+  }
+
+  wordFactory := WordFactory{Storage: storage, Settings: DefaultSettingsObject(), TFIDF: tfidf.NewMemoryTFIDF()}
+  err = wordFactory.IndexPublication(publication.ID)
+  if err != nil {
+    t.Errorf("Error indexing publication: %v", err)
+  }
+
+  err = wordFactory.ReplacePublication(Publication{
+    SourceID: "care",
+    Text: "Take the dog home, the dog is tired.",
+  })
+  if err != nil {
+    t.Errorf("Error replacing publication: %v", err)
+  }
+
+  fixtures := []struct {
+    Word string
+    ExpectedTF float64
+  }{
+    {"dog", 1.0},
+    {"boy", 0.5},
+  }
+
+  for _, fixture := range fixtures {
+    tf, err := wordFactory.TFIDF.TermFrequency(fixture.Word, publication.ID)
+    if err != nil {
+      t.Errorf("Error obtaining term frequency: %v", err)
+    }
+    if tf != fixture.ExpectedTF {
+      t.Errorf("Expected a term frequency of %v for %q, obtained %v", fixture.ExpectedTF, fixture.Word, tf)
+    }
+  }
+}
@@ -1,15 +1,43 @@
 package philarios
 
+/*
+Settings controls how a WordFactory gathers and scores the contexts of words.
+Categories restricts the publications which contexts are drawn from to those
+tagged with any of the categories, or with all of them if MatchAllCategories
+is set. An empty list of categories draws from every publication. MaxParagraphs caps
+the number of paragraphs that the contexts of a single word are gathered from,
+where zero means that there is no cap. When RankParagraphs is set, the most
+relevant paragraphs are used rather than an arbitrary subset of them. Contexts
+are only drawn from publications written in Language. When Authors is
+non-empty, contexts are only drawn from publications by those authors, and
+StartDate and EndDate restrict them to publications dated within an inclusive
+range in the form YYYY-MM-DD, where an empty bound leaves that side of the
+range open.
+*/
 type Settings struct {
   WordsToCapture int
+  Categories []string
+  MatchAllCategories bool
+  MaxParagraphs int
+  RankParagraphs bool
+  Language string
+  Authors []string
+  StartDate string
+  EndDate string
 }
 
 const (
   WordsToCapture = 2
+  MaxParagraphs = 1000
 )
 
 func DefaultSettingsObject() (Settings) {
   return Settings{
-    WordsToCapture,
+    WordsToCapture: WordsToCapture,
+    Categories: []string{},
+    MaxParagraphs: MaxParagraphs,
+    RankParagraphs: true,
+    Language: DefaultLanguage,
+    Authors: []string{},
   }
 }
@@ -0,0 +1,498 @@
+package philarios
+
+import (
+  "context"
+  "errors"
+  "hash/fnv"
+  "sort"
+)
+
+/*
+ShardedStorage is a Storage which partitions publications across several
+underlying Storage shards by a hash of their SourceID. Queries are sent to
+every shard concurrently and their results are merged. The ids of
+publications and paragraphs in a ShardedStorage encode the shard which holds
+them, so they differ from the ids used within the shard. Since the shard of a
+publication depends on the number of shards, shards can't be added to or
+removed from a ShardedStorage which holds publications.
+*/
+type ShardedStorage struct {
+  Shards []Storage
+  ctx context.Context
+}
+
+/*
+ErrNoShards is returned when creating a ShardedStorage without any shards, and
+by the methods of a ShardedStorage which was created without any.
+*/
+var ErrNoShards = errors.New("Sharded storage needs at least one shard")
+
+/*
+NewShardedStorage returns a ShardedStorage which partitions publications
+across the given shards. ErrNoShards is returned if there are no shards.
+*/
+func NewShardedStorage(shards ...Storage) (ShardedStorage, error) {
+  if len(shards) == 0 {
+    return ShardedStorage{}, ErrNoShards
+  }
+  return ShardedStorage{Shards: shards}, nil
+}
+
+/*
+WithContext returns a copy of the storage whose shards are all bound to ctx.
+*/
+func (s ShardedStorage) WithContext(ctx context.Context) (Storage) {
+  shards := make([]Storage, len(s.Shards))
+  for i, shard := range s.Shards {
+    shards[i] = shard.WithContext(ctx)
+  }
+  return ShardedStorage{Shards: shards, ctx: ctx}
+}
+
+/*
+Context returns the context that the storage's shards are bound to, which is
+context.Background() unless one was given to WithContext.
+*/
+func (s ShardedStorage) Context() (context.Context) {
+  if s.ctx == nil {
+    return context.Background()
+  }
+  return s.ctx
+}
+
+/*
+shardFor returns the index of the shard holding the publication with the given
+SourceID.
+*/
+func (s ShardedStorage) shardFor(sourceID string) (int, error) {
+  if len(s.Shards) == 0 {
+    return 0, ErrNoShards
+  }
+
+  hash := fnv.New32a()
+  hash.Write([]byte(sourceID))
+  return int(hash.Sum32() % uint32(len(s.Shards))), nil
+}
+
+/*
+globalId returns the id in the ShardedStorage of the publication or paragraph
+with the given id in a shard.
+*/
+func (s ShardedStorage) globalId(shard, id int) (int) {
+  return id * len(s.Shards) + shard
+}
+
+/*
+localId returns the shard holding the publication or paragraph with the given
+id in the ShardedStorage, along with its id in that shard. notFound is returned
+if the id can't have been returned by the ShardedStorage.
+*/
+func (s ShardedStorage) localId(id int, notFound error) (shard int, local int, err error) {
+  if len(s.Shards) == 0 {
+    return 0, 0, ErrNoShards
+  }
+  if id < len(s.Shards) {
+    return 0, 0, notFound
+  }
+  return id % len(s.Shards), id / len(s.Shards), nil
+}
+
+/*
+localAfterId returns the id in a shard after which the shard's publications
+have ids greater than afterId in the ShardedStorage.
+*/
+func (s ShardedStorage) localAfterId(shard, afterId int) (int) {
+  if afterId < shard {
+    return 0
+  }
+  return (afterId - shard) / len(s.Shards)
+}
+
+func (s ShardedStorage) globalParagraph(shard int, paragraph Paragraph) (Paragraph) {
+  paragraph.ID = s.globalId(shard, paragraph.ID)
+  paragraph.PublicationId = s.globalId(shard, paragraph.PublicationId)
+  return paragraph
+}
+
+func (s ShardedStorage) globalParagraphs(shard int, paragraphs []Paragraph) ([]Paragraph) {
+  for i, paragraph := range paragraphs {
+    paragraphs[i] = s.globalParagraph(shard, paragraph)
+  }
+  return paragraphs
+}
+
+/*
+QueryForWord returns the paragraphs containing the query word from every
+shard. If categories is non-empty, only paragraphs from publications tagged
+with any of the categories are returned.
+*/
+func (s ShardedStorage) QueryForWord(word string, categories []string) ([]Paragraph, error) {
+  return s.QueryForWordWithOptions(word, QueryOptions{Categories: categories})
+}
+
+/*
+QueryForWordWithOptions returns the paragraphs containing the query word which
+also satisfy the given options.
+*/
+func (s ShardedStorage) QueryForWordWithOptions(word string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return s.EachParagraphForWord(word, options, handler)
+  })
+}
+
+/*
+EachParagraphForWord calls the handler with each paragraph containing the
+query word which also satisfies the given options. The shards are queried
+concurrently, and the handler is called with their paragraphs as they are
+found.
+*/
+func (s ShardedStorage) EachParagraphForWord(word string, options QueryOptions, handler ParagraphHandler) (error) {
+  return s.eachMergedParagraph(options, handler, func(shard Storage, handler ParagraphHandler) (error) {
+    return shard.EachParagraphForWord(word, options, handler)
+  })
+}
+
+/*
+QueryForPhrase returns the paragraphs containing the phrase from every shard.
+If categories is non-empty, only paragraphs from publications tagged with any
+of the categories are returned.
+*/
+func (s ShardedStorage) QueryForPhrase(words []string, categories []string) ([]Paragraph, error) {
+  return s.QueryForPhraseWithOptions(words, QueryOptions{Categories: categories})
+}
+
+/*
+QueryForPhraseWithOptions returns the paragraphs containing the phrase which
+also satisfy the given options.
+*/
+func (s ShardedStorage) QueryForPhraseWithOptions(words []string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return s.EachParagraphForPhrase(words, options, handler)
+  })
+}
+
+/*
+EachParagraphForPhrase calls the handler with each paragraph containing the
+phrase which also satisfies the given options, in the same way as
+EachParagraphForWord.
+*/
+func (s ShardedStorage) EachParagraphForPhrase(words []string, options QueryOptions, handler ParagraphHandler) (error) {
+  return s.eachMergedParagraph(options, handler, func(shard Storage, handler ParagraphHandler) (error) {
+    return shard.EachParagraphForPhrase(words, options, handler)
+  })
+}
+
+/*
+shardQuery runs a query on a shard, calling the handler with each paragraph
+that it matches.
+*/
+type shardQuery func(shard Storage, handler ParagraphHandler) (error)
+
+/*
+shardStream carries the paragraphs matched by a query on one shard as they are
+found, followed by the error which ended the query.
+*/
+type shardStream struct {
+  paragraphs chan Paragraph
+  err chan error
+}
+
+/*
+streamShard starts running the query on a shard bound to ctx, and returns the
+stream of its paragraphs with their ids in the ShardedStorage. The query stops
+once ctx is cancelled.
+*/
+func (s ShardedStorage) streamShard(ctx context.Context, shard int, query shardQuery) (shardStream) {
+  stream := shardStream{make(chan Paragraph), make(chan error, 1)}
+  go func() {
+    err := query(s.Shards[shard].WithContext(ctx), func(paragraph Paragraph) (error) {
+      select {
+      case stream.paragraphs <- s.globalParagraph(shard, paragraph):
+        return nil
+      case <-ctx.Done():
+        return ctx.Err()
+      }
+    })
+    close(stream.paragraphs)
+    stream.err <- err
+  }()
+
+  return stream
+}
+
+/*
+next returns the next paragraph of the stream. ok is false once the query has
+ended, and err is then the error which ended it.
+*/
+func (stream shardStream) next() (paragraph Paragraph, ok bool, err error) {
+  paragraph, ok = <-stream.paragraphs
+  if !ok {
+    err = <-stream.err
+  }
+  return paragraph, ok, err
+}
+
+/*
+eachMergedParagraph runs the query on every shard concurrently, and calls the
+handler with the merged results as the shards find them. When the options are
+ranked, each shard returns its paragraphs in decreasing order of their scores,
+and the shards are merged in that order. Otherwise, the paragraphs of each
+shard follow those of the shards before it. Each shard applies the options'
+limit, so the merged results are limited again. A duplicate of a paragraph
+from another shard is left out unless the options include duplicates, keeping
+whichever of them is merged first. The queries on the shards are cancelled
+once the handler stops the iteration, the limit is reached or a shard fails.
+*/
+func (s ShardedStorage) eachMergedParagraph(options QueryOptions, handler ParagraphHandler, query shardQuery) (error) {
+  ctx, cancel := context.WithCancel(s.Context())
+  defer cancel()
+
+  streams := make([]shardStream, len(s.Shards))
+  for i := range s.Shards {
+    streams[i] = s.streamShard(ctx, i, query)
+  }
+
+  // heads holds the next paragraph of each shard which hasn't ended.
+  heads := make(map[int]Paragraph)
+  advance := func(shard int) (error) {
+    paragraph, ok, err := streams[shard].next()
+    if ok {
+      heads[shard] = paragraph
+    } else {
+      delete(heads, shard)
+    }
+    return err
+  }
+  for i := range streams {
+    if err := advance(i); err != nil {
+      return err
+    }
+  }
+
+  seen := make(map[string]bool)
+  handled := 0
+  for len(heads) > 0 {
+    if options.Limit > 0 && handled >= options.Limit {
+      return nil
+    }
+
+    next := -1
+    for i := range streams {
+      head, ok := heads[i]
+      if !ok {
+        continue
+      }
+      if next == -1 || (options.Ranked && head.Score > heads[next].Score) {
+        next = i
+      }
+      if !options.Ranked {
+        break
+      }
+    }
+
+    paragraph := heads[next]
+    if err := advance(next); err != nil {
+      return err
+    }
+
+    if !options.IncludeDuplicates && paragraph.ContentHash != "" {
+      if seen[paragraph.ContentHash] {
+        continue
+      }
+      seen[paragraph.ContentHash] = true
+    }
+
+    err := handler(paragraph)
+    if err == ErrStopIteration {
+      return nil
+    } else if err != nil {
+      return err
+    }
+    handled++
+  }
+
+  return nil
+}
+
+/*
+NeighboringParagraphs returns the paragraph with the given id together with the
+paragraphs of the same publication which are at most radius positions away
+from it, from the shard holding the paragraph.
+*/
+func (s ShardedStorage) NeighboringParagraphs(paragraphId int, radius int) ([]Paragraph, error) {
+  shard, local, err := s.localId(paragraphId, ErrParagraphNotFound)
+  if err != nil {
+    return nil, err
+  }
+
+  paragraphs, err := s.Shards[shard].NeighboringParagraphs(local, radius)
+  if err != nil {
+    return nil, err
+  }
+  return s.globalParagraphs(shard, paragraphs), nil
+}
+
+/*
+PublicationParagraphs returns the paragraphs of the publication with the given
+id, from the shard holding the publication.
+*/
+func (s ShardedStorage) PublicationParagraphs(publicationId int) ([]Paragraph, error) {
+  shard, local, err := s.localId(publicationId, ErrPublicationNotFound)
+  if err != nil {
+    return nil, err
+  }
+
+  paragraphs, err := s.Shards[shard].PublicationParagraphs(local)
+  if err != nil {
+    return nil, err
+  }
+  return s.globalParagraphs(shard, paragraphs), nil
+}
+
+/*
+AddPublication adds the publication to the shard for its SourceID.
+*/
+func (s ShardedStorage) AddPublication(publication Publication) (error) {
+  shard, err := s.shardFor(publication.SourceID)
+  if err != nil {
+    return err
+  }
+  return s.Shards[shard].AddPublication(publication)
+}
+
+/*
+RemovePublication removes the publication with the given SourceID from its
+shard, and returns the id that the publication had.
+*/
+func (s ShardedStorage) RemovePublication(sourceID string) (int, error) {
+  shard, err := s.shardFor(sourceID)
+  if err != nil {
+    return 0, err
+  }
+  publicationId, err := s.Shards[shard].RemovePublication(sourceID)
+  if err != nil {
+    return 0, err
+  }
+  return s.globalId(shard, publicationId), nil
+}
+
+/*
+ReplacePublication replaces the existing publication with the same SourceID
+in its shard, and returns its id.
+*/
+func (s ShardedStorage) ReplacePublication(publication Publication) (int, error) {
+  shard, err := s.shardFor(publication.SourceID)
+  if err != nil {
+    return 0, err
+  }
+  publicationId, err := s.Shards[shard].ReplacePublication(publication)
+  if err != nil {
+    return 0, err
+  }
+  return s.globalId(shard, publicationId), nil
+}
+
+/*
+GetPublication returns the publication with the given id from the shard
+holding it.
+*/
+func (s ShardedStorage) GetPublication(publicationId int) (Publication, error) {
+  shard, local, err := s.localId(publicationId, ErrPublicationNotFound)
+  if err != nil {
+    return Publication{}, err
+  }
+
+  publication, err := s.Shards[shard].GetPublication(local)
+  if err != nil {
+    return Publication{}, err
+  }
+  publication.ID = s.globalId(shard, publication.ID)
+  return publication, nil
+}
+
+/*
+GetPublicationBySourceID returns the publication with the given SourceID from
+the shard for the SourceID.
+*/
+func (s ShardedStorage) GetPublicationBySourceID(sourceID string) (Publication, error) {
+  shard, err := s.shardFor(sourceID)
+  if err != nil {
+    return Publication{}, err
+  }
+  publication, err := s.Shards[shard].GetPublicationBySourceID(sourceID)
+  if err != nil {
+    return Publication{}, err
+  }
+  publication.ID = s.globalId(shard, publication.ID)
+  return publication, nil
+}
+
+/*
+ListPublications returns the publications selected by the filter from every
+shard, in order of their ids in the ShardedStorage. Each shard lists the
+publications up to the end of the requested page, so pages far from AfterID
+are more expensive to list than the first one.
+*/
+func (s ShardedStorage) ListPublications(filter PublicationFilter) ([]Publication, error) {
+  shardFilter := filter
+  shardFilter.Offset = 0
+  if filter.Limit > 0 {
+    shardFilter.Limit = filter.Offset + filter.Limit
+  }
+
+  publications := make([]Publication, 0)
+  for i, shard := range s.Shards {
+    shardFilter.AfterID = s.localAfterId(i, filter.AfterID)
+    shardPublications, err := shard.ListPublications(shardFilter)
+    if err != nil {
+      return nil, err
+    }
+
+    for _, publication := range shardPublications {
+      publication.ID = s.globalId(i, publication.ID)
+      publications = append(publications, publication)
+    }
+  }
+
+  sort.Sort(byPublicationId(publications))
+  if filter.Offset >= len(publications) {
+    return []Publication{}, nil
+  }
+  publications = publications[filter.Offset:]
+  if filter.Limit > 0 && len(publications) > filter.Limit {
+    publications = publications[:filter.Limit]
+  }
+
+  return publications, nil
+}
+
+type byPublicationId []Publication
+
+func (b byPublicationId) Len() int {
+  return len(b)
+}
+
+func (b byPublicationId) Less(i, j int) bool {
+  return b[i].ID < b[j].ID
+}
+
+func (b byPublicationId) Swap(i, j int) {
+  b[i], b[j] = b[j], b[i]
+}
+
+/*
+CorpusStats returns the combined size of the corpus in every shard.
+DuplicateParagraphs only counts duplicates within each shard.
+*/
+func (s ShardedStorage) CorpusStats() (CorpusStats, error) {
+  stats := newCorpusStats()
+  for _, shard := range s.Shards {
+    shardStats, err := shard.CorpusStats()
+    if err != nil {
+      return stats, err
+    }
+    stats.merge(shardStats)
+  }
+
+  return stats, nil
+}
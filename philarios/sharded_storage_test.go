@@ -0,0 +1,219 @@
+package philarios
+
+import (
+  "reflect"
+  "testing"
+)
+
+func setupShardedStorage() (ShardedStorage, error) {
+  storage, err := NewShardedStorage(NewMemoryStorage(), NewMemoryStorage(), NewMemoryStorage())
+  if err != nil {
+    return storage, err
+  }
+
+  publications := []Publication{
+    {SourceID: "great-expectations", Author: "Charles Dickens", Date: "1860-01-12",
+      Categories: []string{"classic"}, Text: "Ours was the marsh country, down by the river.\nPip, sir."},
+    {SourceID: "tombstone", Author: "Charles Dickens", Date: "1860-01-12",
+      Categories: []string{"classic"}, Text: "Also Georgiana wife of the above."},
+    {SourceID: "river", Author: "wikipedia", Date: "2014-08-01",
+      Text: "A river is a natural flowing watercourse.\nThe river wound towards the sea."},
+    {SourceID: "reprint", Author: "Charles Dickens", Text: "Pip, sir!"},
+  }
+
+  for _, publication := range publications {
+    err := storage.AddPublication(publication)
+    if err != nil {
+      return storage, err
+    }
+  }
+
+  return storage, nil
+}
+
+func TestQueryingShardedStorage(t *testing.T) {
+  storage, err := setupShardedStorage()
+  if err != nil {
+    t.Errorf("Error setting up sharded storage and seeding with data: %s", err.Error())
+  }
+
+  shardsUsed := make(map[int]bool)
+  for _, sourceID := range []string{"great-expectations", "tombstone", "river", "reprint"} {
+    shard, err := storage.shardFor(sourceID)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when finding the shard for %s: %s", sourceID, err.Error())
+    }
+    shardsUsed[shard] = true
+  }
+  if len(shardsUsed) < 2 {
+    t.Errorf("Publications should have been spread across shards, instead used shards %v", shardsUsed)
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    ExpectedParagraphs int
+  }{
+    {"river", QueryOptions{}, 3},
+    {"river", QueryOptions{Limit: 2}, 2},
+    {"river", QueryOptions{Authors: []string{"wikipedia"}}, 2},
+    {"pip", QueryOptions{}, 1},
+    {"pip", QueryOptions{IncludeDuplicates: true}, 2},
+    {"georgiana", QueryOptions{Categories: []string{"classic"}}, 1},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := storage.QueryForWordWithOptions(fixture.Word, fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q with options %+v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Options, len(paragraphs))
+    }
+  }
+
+  paragraphs, err := storage.QueryForWordWithOptions("river", QueryOptions{Ranked: true})
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+  for i := 1; i < len(paragraphs); i++ {
+    if paragraphs[i].Score > paragraphs[i - 1].Score {
+      t.Errorf("Ranked paragraphs should be in decreasing order of score, instead obtained %v", paragraphs)
+    }
+  }
+
+  paragraphs, _ = storage.QueryForPhrase([]string{"river", "wound"}, nil)
+  if len(paragraphs) != 1 {
+    t.Errorf("Should have obtained 1 paragraph for the phrase 'river wound', instead obtained %d", len(paragraphs))
+    return
+  }
+
+  neighbors, err := storage.NeighboringParagraphs(paragraphs[0].ID, 1)
+  if err != nil || len(neighbors) != 2 || neighbors[1].ID != paragraphs[0].ID {
+    t.Errorf("Should have obtained the neighbors of paragraph %d, instead obtained %v (err=%v)",
+      paragraphs[0].ID, neighbors, err)
+  }
+
+  publication, err := storage.GetPublication(paragraphs[0].PublicationId)
+  if err != nil || publication.SourceID != "river" {
+    t.Errorf("Should have obtained the publication of paragraph %d, instead obtained %+v (err=%v)",
+      paragraphs[0].ID, publication, err)
+  }
+}
+
+func TestStoppingIterationOverShardedStorage(t *testing.T) {
+  storage, err := setupShardedStorage()
+  if err != nil {
+    t.Errorf("Error setting up sharded storage and seeding with data: %s", err.Error())
+  }
+
+  for _, options := range []QueryOptions{{}, {Ranked: true}} {
+    handled := 0
+    err = storage.EachParagraphForWord("river", options, func(paragraph Paragraph) (error) {
+      handled++
+      return ErrStopIteration
+    })
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when stopping iteration: %s", err.Error())
+    }
+    if handled != 1 {
+      t.Errorf("Should have handled 1 paragraph with options %+v before stopping, instead handled %d", options, handled)
+    }
+  }
+
+  _, err = NewShardedStorage()
+  if err != ErrNoShards {
+    t.Errorf("Should have obtained ErrNoShards without any shards, instead obtained %v", err)
+  }
+
+  empty := ShardedStorage{}
+  err = empty.AddPublication(Publication{SourceID: "river"})
+  if err != ErrNoShards {
+    t.Errorf("Should have obtained ErrNoShards when adding to a storage without shards, instead obtained %v", err)
+  }
+  _, err = empty.GetPublication(3)
+  if err != ErrNoShards {
+    t.Errorf("Should have obtained ErrNoShards when getting from a storage without shards, instead obtained %v", err)
+  }
+  _, err = empty.GetPublicationBySourceID("river")
+  if err != ErrNoShards {
+    t.Errorf("Should have obtained ErrNoShards when looking up in a storage without shards, instead obtained %v", err)
+  }
+}
+
+func TestPublicationCatalogInShardedStorage(t *testing.T) {
+  storage, err := setupShardedStorage()
+  if err != nil {
+    t.Errorf("Error setting up sharded storage and seeding with data: %s", err.Error())
+  }
+
+  all, err := storage.ListPublications(PublicationFilter{})
+  if err != nil || len(all) != 4 {
+    t.Errorf("Should have listed 4 publications, instead listed %v (err=%v)", all, err)
+    return
+  }
+
+  for _, publication := range all {
+    found, err := storage.GetPublicationBySourceID(publication.SourceID)
+    if err != nil || found.ID != publication.ID {
+      t.Errorf("Should have obtained publication %d for %q, instead obtained %+v (err=%v)",
+        publication.ID, publication.SourceID, found, err)
+    }
+
+    paragraphs, err := storage.PublicationParagraphs(publication.ID)
+    if err != nil || len(paragraphs) == 0 || paragraphs[0].PublicationId != publication.ID {
+      t.Errorf("Should have obtained the paragraphs of publication %d, instead obtained %v (err=%v)",
+        publication.ID, paragraphs, err)
+    }
+  }
+
+  page, _ := storage.ListPublications(PublicationFilter{Offset: 1, Limit: 2})
+  if !reflect.DeepEqual(page, all[1:3]) {
+    t.Errorf("Should have listed publications %v, instead listed %v", all[1:3], page)
+  }
+
+  for i, publication := range all {
+    page, _ = storage.ListPublications(PublicationFilter{AfterID: publication.ID, Limit: 2})
+    expected := all[i + 1:]
+    if len(expected) > 2 {
+      expected = expected[:2]
+    }
+    if !reflect.DeepEqual(page, expected) {
+      t.Errorf("Should have listed publications %v after %d, instead listed %v", expected, publication.ID, page)
+    }
+  }
+
+  dickens, _ := storage.ListPublications(PublicationFilter{Author: "Charles Dickens"})
+  if len(dickens) != 3 {
+    t.Errorf("Should have listed 3 publications by Charles Dickens, instead listed %d", len(dickens))
+  }
+
+  fixtures := []int{0, 1, 2, 1000}
+  for _, publicationId := range fixtures {
+    _, err = storage.GetPublication(publicationId)
+    if err != ErrPublicationNotFound {
+      t.Errorf("Should have obtained ErrPublicationNotFound for publication %d, instead obtained %v", publicationId, err)
+    }
+  }
+
+  removedId, err := storage.RemovePublication("river")
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when removing a publication: %s", err.Error())
+  }
+  _, err = storage.GetPublication(removedId)
+  if err != ErrPublicationNotFound {
+    t.Errorf("Should have obtained ErrPublicationNotFound for a removed publication, instead obtained %v", err)
+  }
+
+  stats, err := storage.CorpusStats()
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when obtaining corpus stats: %s", err.Error())
+  }
+  expected := CorpusCounts{3, 4, 19}
+  if stats.CorpusCounts != expected || stats.ByAuthor["Charles Dickens"] != expected ||
+    stats.EarliestDate != "1860-01-12" || stats.LatestDate != "1860-01-12" {
+    t.Errorf("Obtained unexpected corpus stats %+v", stats)
+  }
+}
@@ -0,0 +1,218 @@
+package philarios
+
+import (
+  "database/sql"
+  "sort"
+)
+
+/*
+CorpusCounts are the sizes of a part of the corpus. Tokens are the words of
+paragraphs, as split by SplitWords.
+*/
+type CorpusCounts struct {
+  Publications int
+  Paragraphs int
+  Tokens int
+}
+
+/*
+CorpusStats describes the size of the corpus in storage, in total and broken
+down by the Type, Author and categories of publications. A publication with
+several categories is counted under each of them. EarliestDate and LatestDate
+are the range of the publications' dates in the form YYYY-MM-DD, and are empty
+if no publication has a date. DuplicateParagraphs is the number of paragraphs
+which have the same ContentHash as a paragraph stored before them, and which
+are skipped by queries which also match that paragraph.
+*/
+type CorpusStats struct {
+  CorpusCounts
+  DuplicateParagraphs int
+  EarliestDate string
+  LatestDate string
+  ByType map[string]CorpusCounts
+  ByAuthor map[string]CorpusCounts
+  ByCategory map[string]CorpusCounts
+}
+
+func newCorpusStats() (CorpusStats) {
+  return CorpusStats{
+    ByType: make(map[string]CorpusCounts),
+    ByAuthor: make(map[string]CorpusCounts),
+    ByCategory: make(map[string]CorpusCounts),
+  }
+}
+
+/*
+add counts a publication with the given number of paragraphs and tokens
+towards the totals and each of its breakdowns.
+*/
+func (c *CorpusStats) add(publication Publication, paragraphs, tokens int) {
+  counts := CorpusCounts{1, paragraphs, tokens}
+  c.CorpusCounts = c.CorpusCounts.plus(counts)
+  c.ByType[publication.Type] = c.ByType[publication.Type].plus(counts)
+  c.ByAuthor[publication.Author] = c.ByAuthor[publication.Author].plus(counts)
+  for _, category := range uniqueStrings(publication.Categories) {
+    c.ByCategory[category] = c.ByCategory[category].plus(counts)
+  }
+
+  if publication.Date != "" {
+    if c.EarliestDate == "" || publication.Date < c.EarliestDate {
+      c.EarliestDate = publication.Date
+    }
+    if c.LatestDate == "" || publication.Date > c.LatestDate {
+      c.LatestDate = publication.Date
+    }
+  }
+}
+
+/*
+merge adds the counts of another part of the corpus, such as a shard, to the
+stats.
+*/
+func (c *CorpusStats) merge(other CorpusStats) {
+  c.CorpusCounts = c.CorpusCounts.plus(other.CorpusCounts)
+  c.DuplicateParagraphs += other.DuplicateParagraphs
+  for key, counts := range other.ByType {
+    c.ByType[key] = c.ByType[key].plus(counts)
+  }
+  for key, counts := range other.ByAuthor {
+    c.ByAuthor[key] = c.ByAuthor[key].plus(counts)
+  }
+  for key, counts := range other.ByCategory {
+    c.ByCategory[key] = c.ByCategory[key].plus(counts)
+  }
+
+  if other.EarliestDate != "" && (c.EarliestDate == "" || other.EarliestDate < c.EarliestDate) {
+    c.EarliestDate = other.EarliestDate
+  }
+  if other.LatestDate > c.LatestDate {
+    c.LatestDate = other.LatestDate
+  }
+}
+
+func (c CorpusCounts) plus(other CorpusCounts) (CorpusCounts) {
+  return CorpusCounts{
+    c.Publications + other.Publications,
+    c.Paragraphs + other.Paragraphs,
+    c.Tokens + other.Tokens,
+  }
+}
+
+/*
+SortedKeys returns the keys of a breakdown of the corpus in sorted order.
+*/
+func SortedKeys(breakdown map[string]CorpusCounts) ([]string) {
+  keys := make([]string, 0, len(breakdown))
+  for key := range breakdown {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  return keys
+}
+
+/*
+CorpusStats returns the size of the corpus in the database. The breakdowns are
+counted by the database, and every count is read within a single read-only
+transaction, so they are consistent with each other even while publications
+are being added.
+*/
+func (p PostgresStorage) CorpusStats() (CorpusStats, error) {
+  stats := newCorpusStats()
+  ctx := p.Context()
+  txn, err := p.SQLDatabase.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+  if err != nil {
+    return stats, err
+  }
+  defer txn.Rollback()
+
+  err = txn.QueryRowContext(ctx,
+    `SELECT COALESCE(to_char(MIN(date), 'YYYY-MM-DD'), ''),
+        COALESCE(to_char(MAX(date), 'YYYY-MM-DD'), ''),
+        (SELECT COUNT(*) FROM paragraphs WHERE duplicate)
+      FROM publications`).Scan(&stats.EarliestDate, &stats.LatestDate, &stats.DuplicateParagraphs)
+  if err != nil {
+    return stats, err
+  }
+
+  // Each publication is counted once per breakdown, so the paragraphs are
+  // counted per publication before being grouped.
+  rows, err := txn.QueryContext(ctx,
+    `WITH publication_counts AS (
+       SELECT publications.id, COALESCE(publications.type, '') AS type,
+         COALESCE(publications.author, '') AS author,
+         COUNT(paragraphs.id) AS paragraphs,
+         COALESCE(SUM(paragraphs.token_count), 0) AS tokens
+       FROM publications
+       LEFT JOIN paragraphs ON paragraphs.publication = publications.id
+       GROUP BY publications.id)
+     SELECT 'total', '', COUNT(*), COALESCE(SUM(paragraphs), 0), COALESCE(SUM(tokens), 0)
+       FROM publication_counts
+     UNION ALL
+     SELECT 'type', type, COUNT(*), SUM(paragraphs), SUM(tokens)
+       FROM publication_counts GROUP BY type
+     UNION ALL
+     SELECT 'author', author, COUNT(*), SUM(paragraphs), SUM(tokens)
+       FROM publication_counts GROUP BY author
+     UNION ALL
+     SELECT 'category', categories.category, COUNT(*), SUM(paragraphs), SUM(tokens)
+       FROM publication_counts
+       JOIN (SELECT DISTINCT publication, category FROM categories) AS categories
+         ON categories.publication = publication_counts.id
+       GROUP BY categories.category`)
+  if err != nil {
+    return stats, err
+  }
+  defer rows.Close()
+
+  breakdowns := map[string]map[string]CorpusCounts{
+    "type": stats.ByType,
+    "author": stats.ByAuthor,
+    "category": stats.ByCategory,
+  }
+  for rows.Next() {
+    var breakdown, key string
+    var counts CorpusCounts
+    err = rows.Scan(&breakdown, &key, &counts.Publications, &counts.Paragraphs, &counts.Tokens)
+    if err != nil {
+      return stats, err
+    }
+
+    if breakdown == "total" {
+      stats.CorpusCounts = counts
+    } else {
+      breakdowns[breakdown][key] = counts
+    }
+  }
+
+  return stats, rows.Err()
+}
+
+/*
+CorpusStats returns the size of the corpus in the index.
+*/
+func (m MemoryStorage) CorpusStats() (CorpusStats, error) {
+  if err := m.Context().Err(); err != nil {
+    return CorpusStats{}, err
+  }
+
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  stats := newCorpusStats()
+  contentHashes := make(map[string]bool)
+  for publicationId, publication := range m.index.publications {
+    paragraphIndices := m.index.publicationParagraphs[publicationId]
+    tokens := 0
+    for _, paragraphIndex := range paragraphIndices {
+      paragraph := m.index.paragraphs[paragraphIndex]
+      tokens += len(SplitWords(paragraph.Body))
+      if contentHashes[paragraph.ContentHash] {
+        stats.DuplicateParagraphs++
+      }
+      contentHashes[paragraph.ContentHash] = true
+    }
+    stats.add(publication, len(paragraphIndices), tokens)
+  }
+
+  return stats, nil
+}
@@ -0,0 +1,75 @@
+package philarios
+
+import (
+  "database/sql"
+  "reflect"
+  "testing"
+)
+
+/*
+checkCorpusStats adds publications to the empty storage and checks the corpus
+stats that it returns for them.
+*/
+func checkCorpusStats(t *testing.T, storage Storage) {
+  publications := []Publication{
+    {SourceID: "a", Author: "Charles Dickens", Type: "book", Date: "1860-01-12",
+      Categories: []string{"classic", "dickens"}, Text: "Pip, sir.\nOnce more, said the man."},
+    {SourceID: "b", Author: "Charles Dickens", Type: "book", Date: "1843-12-19",
+      Categories: []string{"classic"}, Text: "Marley was dead: to begin with."},
+    {SourceID: "c", Author: "wikipedia", Type: "wikipedia_article", Text: "A name is a term.\nPip, sir!"},
+  }
+  for _, publication := range publications {
+    err := storage.AddPublication(publication)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+    }
+  }
+
+  stats, err := storage.CorpusStats()
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when obtaining corpus stats: %s", err.Error())
+  }
+
+  expected := CorpusStats{
+    CorpusCounts: CorpusCounts{3, 5, 20},
+    DuplicateParagraphs: 1,
+    EarliestDate: "1843-12-19",
+    LatestDate: "1860-01-12",
+    ByType: map[string]CorpusCounts{
+      "book": {2, 3, 13},
+      "wikipedia_article": {1, 2, 7},
+    },
+    ByAuthor: map[string]CorpusCounts{
+      "Charles Dickens": {2, 3, 13},
+      "wikipedia": {1, 2, 7},
+    },
+    ByCategory: map[string]CorpusCounts{
+      "classic": {2, 3, 13},
+      "dickens": {1, 2, 7},
+    },
+  }
+
+  if !reflect.DeepEqual(stats, expected) {
+    t.Errorf("Obtained unexpected corpus stats. Expected %+v, but obtained %+v", expected, stats)
+  }
+}
+
+func TestCorpusStatsInMemoryStorage(t *testing.T) {
+  checkCorpusStats(t, NewMemoryStorage())
+}
+
+func TestCorpusStatsInPostgresDatabase(t *testing.T) {
+  db, err := sql.Open(testDriverName, testDataSourceName)
+  if err != nil {
+    t.Errorf("Error opening database: %s", err.Error())
+  }
+
+  philariosDatabase := PostgresStorage{SQLDatabase: db}
+  teardownDatabase(db)
+  err = philariosDatabase.Migrate()
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when migrating the database: %s", err.Error())
+  }
+
+  checkCorpusStats(t, philariosDatabase)
+}
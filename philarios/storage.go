@@ -1,51 +1,109 @@
 package philarios
 
 import (
+  "github.com/wangjohn/updike/pgcopy"
   "github.com/wangjohn/updike/textprocessor"
   "github.com/lib/pq"
+  "context"
   "database/sql"
+  "errors"
+  "fmt"
+  "strings"
 )
 
+/*
+Storage holds publications and answers queries for their paragraphs. WithContext
+returns a copy of the storage whose methods are bound to the given context, so
+that they return the context's error instead of completing once it is
+cancelled or its deadline passes.
+*/
 type Storage interface {
+  WithContext(ctx context.Context) (Storage)
   QueryForWord(word string, categories []string) ([]Paragraph, error)
+  QueryForWordWithOptions(word string, options QueryOptions) ([]Paragraph, error)
+  EachParagraphForWord(word string, options QueryOptions, handler ParagraphHandler) (error)
+  QueryForPhrase(words []string, categories []string) ([]Paragraph, error)
+  QueryForPhraseWithOptions(words []string, options QueryOptions) ([]Paragraph, error)
+  EachParagraphForPhrase(words []string, options QueryOptions, handler ParagraphHandler) (error)
+  NeighboringParagraphs(paragraphId int, radius int) ([]Paragraph, error)
+  PublicationParagraphs(publicationId int) ([]Paragraph, error)
   AddPublication(publication Publication) (error)
+  RemovePublication(sourceID string) (int, error)
+  ReplacePublication(publication Publication) (int, error)
+  GetPublication(publicationId int) (Publication, error)
+  GetPublicationBySourceID(sourceID string) (Publication, error)
+  ListPublications(filter PublicationFilter) ([]Publication, error)
+  CorpusStats() (CorpusStats, error)
 }
 
+/*
+ErrPublicationNotFound is returned when a publication is expected to exist in
+storage but does not.
+*/
+var ErrPublicationNotFound = errors.New("Publication does not exist")
+
+/*
+ErrParagraphNotFound is returned when a paragraph is expected to exist in
+storage but does not.
+*/
+var ErrParagraphNotFound = errors.New("Paragraph does not exist")
+
+/*
+ErrStopIteration may be returned by a ParagraphHandler to stop iterating over
+paragraphs early. The iteration then returns without an error.
+*/
+var ErrStopIteration = errors.New("Stop iteration")
+
+/*
+ParagraphHandler is called with each paragraph matched by a query, in turn.
+Returning an error stops the iteration, and the error is returned from the
+query unless it is ErrStopIteration.
+*/
+type ParagraphHandler func(paragraph Paragraph) (error)
+
+const (
+  DefaultLanguage = "english"
+)
+
+/*
+PostgresStorage is an implementation of Storage backed by a Postgres database.
+Queries made through a PostgresStorage returned by WithContext are cancelled
+along with its context.
+*/
 type PostgresStorage struct {
   SQLDatabase *sql.DB
+  ctx context.Context
+}
+
+/*
+WithContext returns a copy of the storage which uses ctx for all of its
+database calls.
+*/
+func (p PostgresStorage) WithContext(ctx context.Context) (Storage) {
+  p.ctx = ctx
+  return p
 }
 
-var philariosSchema = `
-CREATE TABLE IF NOT EXISTS publications (
-  id bigserial PRIMARY KEY,
-  title text,
-  author text,
-  editor text,
-  date date,
-  source_id text,
-  source_url text,
-  encoding text,
-  type text
-);
-
-CREATE TABLE IF NOT EXISTS categories (
-  id bigserial PRIMARY KEY,
-  publication integer REFERENCES publications (id),
-  category text
-);
-
-CREATE TABLE IF NOT EXISTS paragraphs (
-  id bigserial PRIMARY KEY,
-  publication integer REFERENCES publications (id),
-  body text
-);
-`
+/*
+Context returns the context that the storage's database calls are made with,
+which is context.Background() unless one was given to WithContext.
+*/
+func (p PostgresStorage) Context() (context.Context) {
+  if p.ctx == nil {
+    return context.Background()
+  }
+  return p.ctx
+}
 
 /*
 Publication is a structure which represents any type of publication (such as
-books or articles) which contains text.
+books or articles) which contains text. Language is the name of the Postgres
+text search configuration used for the publication's text, such as "english",
+"french" or "german". An empty Language means DefaultLanguage. ID is assigned
+by storage when the publication is added, and is ignored when adding one.
 */
 type Publication struct {
+  ID int
   Title string
   Author string
   Editor string
@@ -54,138 +112,669 @@ type Publication struct {
   SourceURL string
   Encoding string
   Type string
+  Language string
   Text string
   Categories []string
 }
 
+func publicationLanguage(publication Publication) (string) {
+  if publication.Language == "" {
+    return DefaultLanguage
+  }
+  return strings.ToLower(publication.Language)
+}
+
+/*
+Paragraph is a paragraph of a publication's text. ID identifies the paragraph
+in storage, and Position is its index among the paragraphs of the publication,
+starting at zero, or -1 if it hasn't been recorded yet. StartOffset and EndOffset are the byte offsets of the
+paragraph's first byte and of the byte just after its last byte in the
+publication's text, or -1 if the offsets weren't recorded. ContentHash
+identifies the paragraph's normalized body, as computed by ContentHash, and is
+empty if it wasn't recorded. When a paragraph is returned from a query, Score
+is its relevance to the query, where higher scores are more relevant.
+*/
 type Paragraph struct {
+  ID int
   PublicationId int
+  Position int
+  StartOffset int
+  EndOffset int
   Body string
+  ContentHash string
+  Score float64
+}
+
+/*
+QueryOptions restricts the paragraphs returned by a query. When Categories is
+non-empty, only paragraphs from publications tagged with at least one of the
+categories are returned, or with every one of them if MatchAllCategories is
+set. When Limit is positive, at most Limit paragraphs are returned. When Ranked
+is set, paragraphs are returned in decreasing order of their scores, so that a
+limit selects the most relevant paragraphs rather than an arbitrary subset.
+Only paragraphs from publications in the given Language are matched, and an
+empty Language means DefaultLanguage. When Authors is non-empty, only
+paragraphs from publications by one of the authors are returned. StartDate and
+EndDate are inclusive bounds in the form YYYY-MM-DD on the dates of the
+publications, and publications without a date are excluded by either bound.
+Paragraphs with the same ContentHash as another matched paragraph are
+duplicates, and only the first of them to be stored is returned unless
+IncludeDuplicates is set. Paragraphs which the other options exclude are never
+considered, so filtering a query never hides all of a set of duplicates.
+*/
+type QueryOptions struct {
+  Categories []string
+  MatchAllCategories bool
+  Limit int
+  Ranked bool
+  Language string
+  Authors []string
+  StartDate string
+  EndDate string
+  IncludeDuplicates bool
+}
+
+func queryLanguage(options QueryOptions) (string) {
+  return publicationLanguage(Publication{Language: options.Language})
 }
 
 /*
 QueryForWord returns SQL rows of paragraphs containing the query word given as
-an argument. These are returned from the database.
+an argument. These are returned from the database. If categories is non-empty,
+only paragraphs from publications tagged with any of the categories are
+returned.
 */
 func (p PostgresStorage) QueryForWord(word string, categories []string) ([]Paragraph, error) {
-  err := p.EnsureSchema()
+  return p.QueryForWordWithOptions(word, QueryOptions{Categories: categories})
+}
+
+/*
+QueryForWordWithOptions returns the paragraphs containing the query word which
+also satisfy the given options.
+*/
+func (p PostgresStorage) QueryForWordWithOptions(word string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return p.EachParagraphForWord(word, options, handler)
+  })
+}
+
+/*
+EachParagraphForWord calls the handler with each paragraph containing the query
+word which also satisfies the given options. Paragraphs are read from the
+database as they are handled, rather than being loaded all at once, so that
+common words can be queried on large corpora.
+*/
+func (p PostgresStorage) EachParagraphForWord(word string, options QueryOptions, handler ParagraphHandler) (error) {
+  return p.eachParagraph("plainto_tsquery", word, options, handler)
+}
+
+/*
+QueryForPhrase returns the paragraphs containing the words of the phrase next
+to each other and in order. If categories is non-empty, only paragraphs from
+publications tagged with any of the categories are returned.
+*/
+func (p PostgresStorage) QueryForPhrase(words []string, categories []string) ([]Paragraph, error) {
+  return p.QueryForPhraseWithOptions(words, QueryOptions{Categories: categories})
+}
+
+/*
+QueryForPhraseWithOptions returns the paragraphs containing the phrase which
+also satisfy the given options.
+*/
+func (p PostgresStorage) QueryForPhraseWithOptions(words []string, options QueryOptions) ([]Paragraph, error) {
+  return collectParagraphs(func(handler ParagraphHandler) (error) {
+    return p.EachParagraphForPhrase(words, options, handler)
+  })
+}
+
+/*
+EachParagraphForPhrase calls the handler with each paragraph containing the
+phrase which also satisfies the given options. Words of the phrase are matched
+as they are by EachParagraphForWord, and stop words (such as "of" in "in spite
+of") only need to be separated by the same number of words as in the phrase.
+*/
+func (p PostgresStorage) EachParagraphForPhrase(words []string, options QueryOptions, handler ParagraphHandler) (error) {
+  return p.eachParagraph("phraseto_tsquery", strings.Join(words, " "), options, handler)
+}
+
+/*
+eachParagraph calls the handler with each paragraph matching the text search
+query built from text by tsqueryFunction, which is one of the Postgres
+functions converting text into a tsquery.
+*/
+func (p PostgresStorage) eachParagraph(tsqueryFunction, text string, options QueryOptions, handler ParagraphHandler) (error) {
+  rows, err := p.performQuery(tsqueryFunction, text, options)
+  if err != nil {
+    return err
+  }
+  defer rows.Close()
+
+  for rows.Next() {
+    var paragraph Paragraph
+    err = rows.Scan(append(paragraphFields(&paragraph), &paragraph.Score)...)
+    if err != nil {
+      return err
+    }
+
+    err = handler(paragraph)
+    if err == ErrStopIteration {
+      return nil
+    } else if err != nil {
+      return err
+    }
+  }
+
+  return rows.Err()
+}
+
+func (p PostgresStorage) performQuery(tsqueryFunction, text string, options QueryOptions) (*sql.Rows, error) {
+  var args queryArgs
+  tsquery := fmt.Sprintf("%s(%s::regconfig, %s)",
+    tsqueryFunction, args.add(queryLanguage(options)), args.add(text))
+  publicationSets := append([]string{publicationSet(&args, options)}, categorySets(&args, options)...)
+  conditions := matchConditions("paragraphs", tsquery, publicationSets)
+  if !options.IncludeDuplicates {
+    // Keep the first stored of each set of matched duplicates. Paragraphs
+    // without a content hash are never considered duplicates of each other.
+    conditions = append(conditions, `NOT EXISTS (
+      SELECT 1 FROM paragraphs AS original
+      WHERE original.content_hash = paragraphs.content_hash
+      AND original.id < paragraphs.id
+      AND ` + strings.Join(matchConditions("original", tsquery, publicationSets), " AND ") + `)`)
+  }
+
+  // Normalization 1 divides the rank by the logarithm of the paragraph's
+  // length, so that long paragraphs aren't favoured.
+  query := `SELECT ` + paragraphColumns + `, ts_rank(body_tsv, ` + tsquery + `, 1) AS score
+    FROM paragraphs
+    WHERE ` + strings.Join(conditions, " AND ")
+  if options.Ranked {
+    query += " ORDER BY score DESC"
+  }
+  if options.Limit > 0 {
+    query += " LIMIT " + args.add(options.Limit)
+  }
+
+  return p.SQLDatabase.QueryContext(p.Context(), query, args...)
+}
+
+/*
+paragraphColumns are the columns of the paragraphs table which are scanned into
+a Paragraph by paragraphFields.
+*/
+const paragraphColumns = `paragraphs.id, paragraphs.publication,
+  COALESCE(paragraphs.position, -1) AS position,
+  COALESCE(paragraphs.start_offset, -1) AS start_offset,
+  COALESCE(paragraphs.end_offset, -1) AS end_offset,
+  paragraphs.body, COALESCE(paragraphs.content_hash, '') AS content_hash`
+
+func paragraphFields(paragraph *Paragraph) ([]interface{}) {
+  return []interface{}{
+    &paragraph.ID,
+    &paragraph.PublicationId,
+    &paragraph.Position,
+    &paragraph.StartOffset,
+    &paragraph.EndOffset,
+    &paragraph.Body,
+    &paragraph.ContentHash,
+  }
+}
+
+/*
+NeighboringParagraphs returns the paragraph with the given id together with the
+paragraphs of the same publication which are at most radius positions away
+from it, in the order in which they appear in the publication. A paragraph
+whose position hasn't been recorded yet is returned without any neighbors.
+ErrParagraphNotFound is returned if there is no paragraph with the given id.
+*/
+func (p PostgresStorage) NeighboringParagraphs(paragraphId int, radius int) ([]Paragraph, error) {
+  if radius < 0 {
+    radius = 0
+  }
+
+  rows, err := p.SQLDatabase.QueryContext(p.Context(),
+    `SELECT ` + paragraphColumns + ` FROM paragraphs
+      JOIN paragraphs AS target ON target.publication = paragraphs.publication
+      WHERE target.id = $1
+      AND (paragraphs.id = target.id
+        OR paragraphs.position BETWEEN target.position - $2 AND target.position + $2)
+      ORDER BY paragraphs.position`, paragraphId, radius)
   if err != nil {
     return nil, err
   }
+  defer rows.Close()
 
-  rows, err := p.performWordQuery(word)
+  paragraphs, err := scanParagraphs(rows)
+  if err != nil {
+    return nil, err
+  }
+  if len(paragraphs) == 0 {
+    return nil, ErrParagraphNotFound
+  }
+
+  return paragraphs, nil
+}
+
+/*
+PublicationParagraphs returns the paragraphs of the publication with the given
+id, in the order in which they appear in the publication.
+ErrPublicationNotFound is returned if there is no such publication.
+*/
+func (p PostgresStorage) PublicationParagraphs(publicationId int) ([]Paragraph, error) {
+  ctx := p.Context()
+  rows, err := p.SQLDatabase.QueryContext(ctx,
+    `SELECT ` + paragraphColumns + ` FROM paragraphs
+      WHERE paragraphs.publication = $1
+      ORDER BY paragraphs.position, paragraphs.id`, publicationId)
+  if err != nil {
+    return nil, err
+  }
   defer rows.Close()
+
+  paragraphs, err := scanParagraphs(rows)
+  if err != nil || len(paragraphs) > 0 {
+    return paragraphs, err
+  }
+
+  // A publication without any text has no paragraphs, so tell it apart from
+  // a missing publication.
+  var exists bool
+  err = p.SQLDatabase.QueryRowContext(ctx,
+    `SELECT EXISTS (SELECT 1 FROM publications WHERE id = $1)`, publicationId).Scan(&exists)
   if err != nil {
     return nil, err
   }
+  if !exists {
+    return nil, ErrPublicationNotFound
+  }
+
+  return paragraphs, nil
+}
 
+func scanParagraphs(rows *sql.Rows) ([]Paragraph, error) {
   paragraphs := make([]Paragraph, 0)
-  var publicationId int
-  var body string
   for rows.Next() {
-    err = rows.Scan(&publicationId, &body)
+    var paragraph Paragraph
+    err := rows.Scan(paragraphFields(&paragraph)...)
     if err != nil {
       return nil, err
     }
-    paragraphs = append(paragraphs, Paragraph{publicationId, body})
+    paragraphs = append(paragraphs, paragraph)
   }
 
-  if err = rows.Err(); err != nil {
+  return paragraphs, rows.Err()
+}
+
+/*
+collectParagraphs runs a query which calls a ParagraphHandler, and returns all
+of the paragraphs that the handler was called with.
+*/
+func collectParagraphs(query func(handler ParagraphHandler) (error)) ([]Paragraph, error) {
+  paragraphs := make([]Paragraph, 0)
+  err := query(func(paragraph Paragraph) (error) {
+    paragraphs = append(paragraphs, paragraph)
+    return nil
+  })
+  if err != nil {
     return nil, err
   }
 
   return paragraphs, nil
 }
 
-func (p PostgresStorage) performWordQuery(word string) (*sql.Rows, error) {
-  return p.SQLDatabase.Query(`SELECT publication, body FROM paragraphs
-    WHERE to_tsvector(body) @@ to_tsquery($1)`, word)
+/*
+matchConditions returns the SQL conditions on the paragraphs table, under the
+given name, which restrict it to paragraphs matching the tsquery from
+publications in every one of publicationSets.
+*/
+func matchConditions(table string, tsquery string, publicationSets []string) ([]string) {
+  conditions := []string{table + ".body_tsv @@ " + tsquery}
+  for _, publicationSet := range publicationSets {
+    conditions = append(conditions, table + ".publication IN (" + publicationSet + ")")
+  }
+  return conditions
+}
+
+/*
+publicationSet returns a SQL query for the ids of the publications with the
+language, authors and dates specified in options. The language is bound as its
+own argument rather than reusing the one given to the tsquery, since Postgres
+types that one as a regconfig.
+*/
+func publicationSet(args *queryArgs, options QueryOptions) (string) {
+  conditions := []string{"language = " + args.add(queryLanguage(options))}
+  authors := uniqueStrings(options.Authors)
+  if len(authors) > 0 {
+    conditions = append(conditions, "author = ANY(" + args.add(pq.Array(authors)) + ")")
+  }
+  if options.StartDate != "" {
+    conditions = append(conditions, fmt.Sprintf("date >= %s::date", args.add(options.StartDate)))
+  }
+  if options.EndDate != "" {
+    conditions = append(conditions, fmt.Sprintf("date <= %s::date", args.add(options.EndDate)))
+  }
+
+  return `SELECT id FROM publications
+    WHERE ` + strings.Join(conditions, " AND ")
+}
+
+/*
+categorySets returns SQL queries for the ids of the publications with the
+categories specified in options.
+*/
+func categorySets(args *queryArgs, options QueryOptions) ([]string) {
+  categories := uniqueStrings(options.Categories)
+  if len(categories) == 0 {
+    return []string{}
+  }
+
+  categoriesArg := args.add(pq.Array(categories))
+  if options.MatchAllCategories {
+    return []string{fmt.Sprintf(`SELECT publication FROM categories
+      WHERE category = ANY(%s)
+      GROUP BY publication
+      HAVING COUNT(DISTINCT category) = %s`, categoriesArg, args.add(len(categories)))}
+  }
+
+  return []string{fmt.Sprintf(`SELECT publication FROM categories
+    WHERE category = ANY(%s)`, categoriesArg)}
+}
+
+/*
+queryArgs collects the arguments of a SQL query as its conditions are built up.
+*/
+type queryArgs []interface{}
+
+/*
+add appends an argument to the query and returns its placeholder.
+*/
+func (a *queryArgs) add(value interface{}) (string) {
+  *a = append(*a, value)
+  return fmt.Sprintf("$%d", len(*a))
+}
+
+func uniqueStrings(values []string) ([]string) {
+  seen := make(map[string]bool)
+  unique := make([]string, 0, len(values))
+  for _, value := range values {
+    if !seen[value] {
+      unique = append(unique, value)
+      seen[value] = true
+    }
+  }
+
+  return unique
 }
 
 /*
 AddPublication adds a new publication to the database, adding data to the
-publications, categories, and paragraphs tables.
+publications, categories, and paragraphs tables. The publication is added in a
+single transaction, so a failure leaves no trace of it in the database. If a
+publication with the same SourceID already exists, nothing is added.
 */
 func (p PostgresStorage) AddPublication(publication Publication) (error) {
-  err := p.EnsureSchema()
+  return p.addPublication(publication, false)
+}
+
+/*
+UpsertPublication adds a publication in the same way as AddPublication, except
+that if a publication with the same SourceID already exists, its metadata is
+updated and its categories and paragraphs are replaced by those of the given
+publication. The existing publication keeps its id.
+*/
+func (p PostgresStorage) UpsertPublication(publication Publication) (error) {
+  return p.addPublication(publication, true)
+}
+
+func (p PostgresStorage) addPublication(publication Publication, replaceExisting bool) (error) {
+  ctx := p.Context()
+  paragraphs, err := textprocessor.ProcessParagraphSpans(publication.Text)
   if err != nil {
     return err
   }
 
-  var publicationId int
-  err = p.SQLDatabase.QueryRow(`SELECT id FROM publications WHERE source_id=$1`,
-    publication.SourceID).Scan(&publicationId)
-  if err == nil {
-    // We expect a sql.ErrNoRows error to occur (since we don't duplicate source_ids)
-    return nil
-  } else if err != nil && err != sql.ErrNoRows {
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+
+  publicationId, err := insertPublication(ctx, txn, publication, replaceExisting)
+  if err == sql.ErrNoRows {
+    // The publication already exists and we aren't replacing it.
+    return txn.Rollback()
+  } else if err != nil {
+    txn.Rollback()
     return err
   }
 
-  txn, err := p.SQLDatabase.Begin()
+  if replaceExisting {
+    err = deletePublicationContents(ctx, txn, publicationId)
+    if err != nil {
+      txn.Rollback()
+      return err
+    }
+  }
+
+  err = insertPublicationContents(ctx, txn, publicationId, publication.Categories, paragraphs)
   if err != nil {
+    txn.Rollback()
     return err
   }
 
-  err = p.SQLDatabase.QueryRow(
-    `INSERT INTO publications (
-        title, author, editor, date, source_id, source_url, type, encoding)
-      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-      RETURNING id`,
+  return txn.Commit()
+}
+
+/*
+RemovePublication removes the publication with the given SourceID, along with
+its categories and paragraphs, and returns the id that the publication had. If
+no such publication exists, ErrPublicationNotFound is returned.
+*/
+func (p PostgresStorage) RemovePublication(sourceID string) (int, error) {
+  ctx := p.Context()
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
+    return 0, err
+  }
+
+  publicationId, err := lockPublication(ctx, txn, sourceID)
+  if err != nil {
+    txn.Rollback()
+    return 0, err
+  }
+
+  err = deletePublicationContents(ctx, txn, publicationId)
+  if err != nil {
+    txn.Rollback()
+    return 0, err
+  }
+
+  _, err = txn.ExecContext(ctx, `DELETE FROM publications WHERE id=$1`, publicationId)
+  if err != nil {
+    txn.Rollback()
+    return 0, err
+  }
+
+  return publicationId, txn.Commit()
+}
+
+/*
+ReplacePublication replaces the metadata, categories and paragraphs of the
+existing publication with the same SourceID, and returns its id. Unlike
+UpsertPublication, ErrPublicationNotFound is returned if there is no
+publication to replace.
+*/
+func (p PostgresStorage) ReplacePublication(publication Publication) (int, error) {
+  ctx := p.Context()
+  paragraphs, err := textprocessor.ProcessParagraphSpans(publication.Text)
+  if err != nil {
+    return 0, err
+  }
+
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
+    return 0, err
+  }
+
+  publicationId, err := lockPublication(ctx, txn, publication.SourceID)
+  if err != nil {
+    txn.Rollback()
+    return 0, err
+  }
+
+  _, err = txn.ExecContext(ctx,
+    `UPDATE publications SET
+        title=$1, author=$2, editor=$3, date=NULLIF($4, '')::date,
+        source_url=$5, type=$6, encoding=$7, language=$8
+      WHERE id=$9`,
     publication.Title,
     publication.Author,
     publication.Editor,
     publication.Date,
-    publication.SourceID,
     publication.SourceURL,
     publication.Type,
-    publication.Encoding).Scan(&publicationId)
+    publication.Encoding,
+    publicationLanguage(publication),
+    publicationId)
   if err != nil {
-    return err
+    txn.Rollback()
+    return 0, err
   }
 
-  categoryStmt, err := txn.Prepare(pq.CopyIn("categories", "publication", "category"))
-  for _, category := range publication.Categories {
-    _, err = categoryStmt.Exec(publicationId, category)
-    if err != nil {
-      return err
-    }
-  }
-  err = categoryStmt.Close()
+  err = deletePublicationContents(ctx, txn, publicationId)
   if err != nil {
-    return err
+    txn.Rollback()
+    return 0, err
   }
 
-  paragraphStmt, err := txn.Prepare(pq.CopyIn("paragraphs", "publication", "body"))
-  paragraphs, err := textprocessor.ProcessParagraphs(publication.Text)
+  err = insertPublicationContents(ctx, txn, publicationId, publication.Categories, paragraphs)
   if err != nil {
-    return err
+    txn.Rollback()
+    return 0, err
   }
 
-  for _, paragraph := range paragraphs {
-    _, err = paragraphStmt.Exec(publicationId, paragraph)
-    if err != nil {
-      return err
-    }
+  return publicationId, txn.Commit()
+}
+
+/*
+lockPublication returns the id of the publication with the given SourceID and
+locks its row until the end of the transaction.
+*/
+func lockPublication(ctx context.Context, txn *sql.Tx, sourceID string) (int, error) {
+  var publicationId int
+  err := txn.QueryRowContext(ctx, `SELECT id FROM publications WHERE source_id=$1 FOR UPDATE`,
+    sourceID).Scan(&publicationId)
+  if err == sql.ErrNoRows {
+    return 0, ErrPublicationNotFound
   }
-  err = paragraphStmt.Close()
+
+  return publicationId, err
+}
+
+/*
+insertPublication inserts the publication's metadata into the publications
+table and returns its id. If a publication with the same SourceID exists, its
+metadata is overwritten when replaceExisting is set, and sql.ErrNoRows is
+returned otherwise.
+*/
+func insertPublication(ctx context.Context, txn *sql.Tx, publication Publication, replaceExisting bool) (int, error) {
+  conflictClause := `DO NOTHING`
+  if replaceExisting {
+    conflictClause = `DO UPDATE SET
+        title = EXCLUDED.title,
+        author = EXCLUDED.author,
+        editor = EXCLUDED.editor,
+        date = EXCLUDED.date,
+        source_url = EXCLUDED.source_url,
+        type = EXCLUDED.type,
+        encoding = EXCLUDED.encoding,
+        language = EXCLUDED.language`
+  }
+
+  var publicationId int
+  err := txn.QueryRowContext(ctx,
+    `INSERT INTO publications (
+        title, author, editor, date, source_id, source_url, type, encoding, language)
+      VALUES ($1, $2, $3, NULLIF($4, '')::date, $5, $6, $7, $8, $9)
+      ON CONFLICT (source_id) ` + conflictClause + `
+      RETURNING id`,
+    publication.Title,
+    publication.Author,
+    publication.Editor,
+    publication.Date,
+    publication.SourceID,
+    publication.SourceURL,
+    publication.Type,
+    publication.Encoding,
+    publicationLanguage(publication)).Scan(&publicationId)
+
+  return publicationId, err
+}
+
+/*
+insertPublicationContents copies the categories and paragraphs of a
+publication into the database, and marks the paragraphs which duplicate a
+paragraph stored before them. Paragraphs with the same content which are
+stored concurrently may both be left unmarked.
+*/
+func insertPublicationContents(ctx context.Context, txn *sql.Tx, publicationId int, categories []string, paragraphs []textprocessor.ParagraphSpan) (error) {
+  categoryRows := make([][]interface{}, len(categories))
+  for i, category := range categories {
+    categoryRows[i] = []interface{}{publicationId, category}
+  }
+
+  err := pgcopy.CopyRows(ctx, txn, pq.CopyIn("categories", "publication", "category"), categoryRows)
   if err != nil {
     return err
   }
 
-  err = txn.Commit()
+  paragraphRows := make([][]interface{}, len(paragraphs))
+  for i, paragraph := range paragraphs {
+    paragraphRows[i] = []interface{}{
+      publicationId, i, paragraph.Start, paragraph.End, len(SplitWords(paragraph.Text)),
+      ContentHash(paragraph.Text), paragraph.Text}
+  }
+
+  err = pgcopy.CopyRows(ctx, txn,
+    pq.CopyIn("paragraphs", "publication", "position", "start_offset", "end_offset",
+      "token_count", "content_hash", "body"),
+    paragraphRows)
   if err != nil {
     return err
   }
 
-  return nil
+  _, err = txn.ExecContext(ctx,
+    `UPDATE paragraphs SET duplicate = true
+      WHERE publication = $1 AND EXISTS (
+        SELECT 1 FROM paragraphs AS original
+        WHERE original.content_hash = paragraphs.content_hash
+        AND original.id < paragraphs.id)`, publicationId)
+  return err
 }
 
 /*
-EnsureSchema is called on a database and ensures that a correct schema has been
-applied so that Queries on the database can occur.
+deletePublicationContents removes the categories and paragraphs of a
+publication, leaving its row in the publications table. The earliest remaining
+duplicate of each removed paragraph takes its place, and is no longer marked
+as a duplicate.
 */
-func (p PostgresStorage) EnsureSchema() (error) {
-  _, err := p.SQLDatabase.Exec(philariosSchema)
+func deletePublicationContents(ctx context.Context, txn *sql.Tx, publicationId int) (error) {
+  _, err := txn.ExecContext(ctx, `DELETE FROM categories WHERE publication=$1`, publicationId)
+  if err != nil {
+    return err
+  }
+
+  _, err = txn.ExecContext(ctx,
+    `UPDATE paragraphs SET duplicate = false
+      WHERE id IN (
+        SELECT MIN(remaining.id) FROM paragraphs AS removed
+        JOIN paragraphs AS remaining ON remaining.content_hash = removed.content_hash
+        WHERE removed.publication = $1 AND NOT removed.duplicate
+        AND remaining.publication <> $1
+        GROUP BY remaining.content_hash)`, publicationId)
+  if err != nil {
+    return err
+  }
+
+  _, err = txn.ExecContext(ctx, `DELETE FROM paragraphs WHERE publication=$1`, publicationId)
   return err
 }
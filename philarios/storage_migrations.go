@@ -0,0 +1,418 @@
+package philarios
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+
+  "github.com/lib/pq"
+  "github.com/wangjohn/updike/migration"
+)
+
+const (
+  storageMigrationComponent = "philarios_storage"
+)
+
+/*
+storageMigrations are the ordered changes to the schema used by
+PostgresStorage. The first migration creates the original schema, and will
+leave databases created before migrations existed untouched. New changes to
+the schema must be appended as new migrations rather than edited in place.
+*/
+var storageMigrations = []migration.Migration{
+  {
+    Version: 1,
+    Description: "create publications, categories and paragraphs",
+    Up: `
+CREATE TABLE IF NOT EXISTS publications (
+  id bigserial PRIMARY KEY,
+  title text,
+  author text,
+  editor text,
+  date date,
+  source_id text,
+  source_url text,
+  encoding text,
+  type text
+);
+
+CREATE TABLE IF NOT EXISTS categories (
+  id bigserial PRIMARY KEY,
+  publication integer REFERENCES publications (id),
+  category text
+);
+
+CREATE TABLE IF NOT EXISTS paragraphs (
+  id bigserial PRIMARY KEY,
+  publication integer REFERENCES publications (id),
+  body text
+);
+`,
+  },
+  {
+    Version: 2,
+    Description: "remove duplicate source ids, make source ids unique and index publication references",
+    Up: `
+-- Publications used to be inserted outside of the transaction adding their
+-- categories and paragraphs, so a source id may have been stored more than
+-- once, with failed inserts leaving publications without any contents. Keep
+-- the earliest publication with paragraphs for each source id, or the earliest
+-- one if none of them have paragraphs, and remove the others.
+CREATE TEMPORARY TABLE duplicate_publications ON COMMIT DROP AS
+  SELECT id FROM (
+    SELECT id, row_number() OVER (
+        PARTITION BY source_id
+        ORDER BY EXISTS (SELECT 1 FROM paragraphs WHERE paragraphs.publication = publications.id) DESC, id
+      ) AS rank
+    FROM publications
+    WHERE source_id IS NOT NULL) AS ranked
+  WHERE rank > 1;
+
+DELETE FROM categories WHERE publication IN (SELECT id FROM duplicate_publications);
+DELETE FROM paragraphs WHERE publication IN (SELECT id FROM duplicate_publications);
+DELETE FROM publications WHERE id IN (SELECT id FROM duplicate_publications);
+
+CREATE UNIQUE INDEX publications_source_id_idx ON publications (source_id);
+CREATE INDEX categories_publication_idx ON categories (publication);
+CREATE INDEX paragraphs_publication_idx ON paragraphs (publication);
+`,
+  },
+  {
+    Version: 3,
+    Description: "store a search vector for each paragraph",
+    Up: `
+ALTER TABLE paragraphs ADD COLUMN body_tsv tsvector;
+CREATE INDEX paragraphs_body_tsv_idx ON paragraphs USING GIN (body_tsv);
+CREATE INDEX paragraphs_body_tsv_missing_idx ON paragraphs (id) WHERE body_tsv IS NULL;
+
+CREATE FUNCTION paragraphs_body_tsv_update() RETURNS trigger AS $$
+BEGIN
+  NEW.body_tsv := to_tsvector(NEW.body);
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER paragraphs_body_tsv_update
+  BEFORE INSERT OR UPDATE OF body ON paragraphs
+  FOR EACH ROW EXECUTE PROCEDURE paragraphs_body_tsv_update();
+`,
+  },
+  {
+    Version: 4,
+    Description: "store the text search language of each publication",
+    Up: `
+ALTER TABLE publications ADD COLUMN language text NOT NULL DEFAULT 'english';
+CREATE INDEX publications_language_idx ON publications (language);
+
+CREATE OR REPLACE FUNCTION paragraphs_body_tsv_update() RETURNS trigger AS $$
+BEGIN
+  NEW.body_tsv := to_tsvector(
+    (SELECT language FROM publications WHERE id = NEW.publication)::regconfig,
+    NEW.body);
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+`,
+  },
+  {
+    Version: 5,
+    Description: "record the position and offsets of each paragraph",
+    Up: `
+ALTER TABLE paragraphs ADD COLUMN position integer;
+ALTER TABLE paragraphs ADD COLUMN start_offset integer;
+ALTER TABLE paragraphs ADD COLUMN end_offset integer;
+CREATE INDEX paragraphs_publication_position_idx ON paragraphs (publication, position);
+CREATE INDEX paragraphs_position_missing_idx ON paragraphs (publication) WHERE position IS NULL;
+`,
+  },
+  {
+    Version: 6,
+    Description: "record the number of tokens in each paragraph",
+    Up: `
+ALTER TABLE paragraphs ADD COLUMN token_count integer;
+CREATE INDEX paragraphs_token_count_missing_idx ON paragraphs (id) WHERE token_count IS NULL;
+`,
+  },
+  {
+    Version: 7,
+    Description: "record a content hash for each paragraph and mark duplicates",
+    Up: `
+ALTER TABLE paragraphs ADD COLUMN content_hash text;
+ALTER TABLE paragraphs ADD COLUMN duplicate boolean NOT NULL DEFAULT false;
+CREATE INDEX paragraphs_content_hash_idx ON paragraphs (content_hash, id);
+CREATE INDEX paragraphs_content_hash_missing_idx ON paragraphs (id) WHERE content_hash IS NULL;
+`,
+  },
+}
+
+/*
+Migrate brings the schema of the database up to date by applying any storage
+migrations that have not yet been applied. It should be called once, before
+the storage is used.
+*/
+func (p PostgresStorage) Migrate() (error) {
+  return migration.MigrateContext(p.Context(), p.SQLDatabase, storageMigrationComponent, storageMigrations)
+}
+
+/*
+BackfillSearchVectors computes the search vectors of paragraphs which were
+stored before the vectors existed, batchSize paragraphs at a time, and returns
+the number of paragraphs that were updated. Those paragraphs cannot be found by
+word queries until they have been backfilled. Since each batch is committed on
+its own, the backfill can be interrupted and resumed. Paragraphs without a body
+or a publication are left without a search vector.
+*/
+func (p PostgresStorage) BackfillSearchVectors(batchSize int) (int, error) {
+  err := validateBatchSize(batchSize)
+  if err != nil {
+    return 0, err
+  }
+
+  total := 0
+  lastId := 0
+  for {
+    // Batches are taken in order of id, so that each one starts after the
+    // last and paragraphs which can't be given a vector aren't taken again.
+    var batchEnd sql.NullInt64
+    var updated int
+    err = p.SQLDatabase.QueryRowContext(p.Context(),
+      `WITH batch AS (
+         SELECT id FROM paragraphs
+         WHERE body_tsv IS NULL AND id > $1
+         ORDER BY id
+         LIMIT $2),
+       updated AS (
+         UPDATE paragraphs
+         SET body_tsv = to_tsvector(publications.language::regconfig, paragraphs.body)
+         FROM publications
+         WHERE publications.id = paragraphs.publication
+         AND paragraphs.body IS NOT NULL
+         AND paragraphs.id IN (SELECT id FROM batch)
+         RETURNING paragraphs.id)
+       SELECT (SELECT MAX(id) FROM batch), (SELECT COUNT(*) FROM updated)`,
+      lastId, batchSize).Scan(&batchEnd, &updated)
+    if err != nil {
+      return total, err
+    }
+
+    total += updated
+    if !batchEnd.Valid {
+      return total, nil
+    }
+    lastId = int(batchEnd.Int64)
+  }
+}
+
+/*
+BackfillPositions numbers the paragraphs of publications which were stored
+before positions existed, in the order in which they were stored, batchSize
+publications at a time. It returns the number of paragraphs that were updated.
+Until they have been backfilled, those paragraphs have a Position of -1 and no
+neighbors. As with BackfillSearchVectors, each batch is committed on its own.
+*/
+func (p PostgresStorage) BackfillPositions(batchSize int) (int, error) {
+  return backfillInBatches(batchSize, p.backfillPositionBatch)
+}
+
+/*
+backfillPositionBatch numbers the paragraphs of at most limit publications with
+unnumbered paragraphs whose ids are greater than afterId. It returns the number
+of paragraphs numbered and the largest publication id in the batch, which is 0
+once there are no publications left.
+*/
+func (p PostgresStorage) backfillPositionBatch(afterId, limit int) (int, int, error) {
+  var batchEnd sql.NullInt64
+  var updated int
+  err := p.SQLDatabase.QueryRowContext(p.Context(),
+    `WITH batch AS (
+       SELECT DISTINCT publication FROM paragraphs
+       WHERE position IS NULL AND publication > $1
+       ORDER BY publication
+       LIMIT $2),
+     numbered AS (
+       SELECT id, row_number() OVER (PARTITION BY publication ORDER BY id) - 1 AS position
+       FROM paragraphs
+       WHERE publication IN (SELECT publication FROM batch)),
+     updated AS (
+       UPDATE paragraphs SET position = numbered.position
+       FROM numbered
+       WHERE paragraphs.id = numbered.id
+       AND paragraphs.position IS NULL
+       RETURNING paragraphs.id)
+     SELECT (SELECT MAX(publication) FROM batch), (SELECT COUNT(*) FROM updated)`,
+    afterId, limit).Scan(&batchEnd, &updated)
+  if err != nil {
+    return 0, 0, err
+  }
+
+  return updated, int(batchEnd.Int64), nil
+}
+
+/*
+backfillInBatches calls batch with the limit batchSize until it reports that
+there is nothing left to backfill, starting each batch after the end of the
+previous one, and returns the total number of rows that were updated.
+*/
+func backfillInBatches(batchSize int, batch func(afterId, limit int) (int, int, error)) (int, error) {
+  err := validateBatchSize(batchSize)
+  if err != nil {
+    return 0, err
+  }
+
+  total := 0
+  lastId := 0
+  for {
+    updated, batchEnd, err := batch(lastId, batchSize)
+    total += updated
+    if err != nil || batchEnd == 0 {
+      return total, err
+    }
+    lastId = batchEnd
+  }
+}
+
+func validateBatchSize(batchSize int) (error) {
+  if batchSize <= 0 {
+    return fmt.Errorf("Backfill batch size must be positive, got %d", batchSize)
+  }
+  return nil
+}
+
+/*
+BackfillContentHashes computes the content hashes of paragraphs which were
+stored before the hashes existed, batchSize paragraphs at a time, and returns
+the number of paragraphs that were updated. Until they have been backfilled,
+those paragraphs are never treated as duplicates. As with
+BackfillSearchVectors, each batch is committed on its own, and paragraphs
+without a body are left without a content hash.
+*/
+func (p PostgresStorage) BackfillContentHashes(batchSize int) (int, error) {
+  return backfillInBatches(batchSize, p.backfillContentHashBatch)
+}
+
+/*
+backfillContentHashBatch hashes at most limit paragraphs without a content hash
+whose ids are greater than afterId, and marks the duplicates among the
+paragraphs with the same hashes. It returns the number of paragraphs hashed and
+the largest id in the batch, which is 0 once there are no paragraphs left.
+*/
+func (p PostgresStorage) backfillContentHashBatch(afterId, limit int) (int, int, error) {
+  ctx := p.Context()
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
+    return 0, 0, err
+  }
+
+  bodies, batchEnd, err := unfilledParagraphs(ctx, txn, "content_hash", afterId, limit)
+  if err != nil {
+    txn.Rollback()
+    return 0, 0, err
+  }
+
+  batchHashes := make([]string, 0, len(bodies))
+  for id, body := range bodies {
+    hash := ContentHash(body)
+    _, err = txn.ExecContext(ctx, `UPDATE paragraphs SET content_hash = $1 WHERE id = $2`, hash, id)
+    if err != nil {
+      txn.Rollback()
+      return 0, 0, err
+    }
+    batchHashes = append(batchHashes, hash)
+  }
+
+  // Paragraphs stored since the hashes existed may duplicate the ones which
+  // were just hashed, so the duplicates are marked again for every paragraph
+  // with one of the hashes.
+  _, err = txn.ExecContext(ctx,
+    `UPDATE paragraphs SET duplicate = (paragraphs.id <> original.id)
+      FROM (
+        SELECT content_hash, MIN(id) AS id FROM paragraphs
+        WHERE content_hash = ANY($1)
+        GROUP BY content_hash) AS original
+      WHERE paragraphs.content_hash = original.content_hash`, pq.Array(batchHashes))
+  if err != nil {
+    txn.Rollback()
+    return 0, 0, err
+  }
+
+  return len(bodies), batchEnd, txn.Commit()
+}
+
+/*
+BackfillTokenCounts counts the tokens of paragraphs which were stored before
+the counts existed, batchSize paragraphs at a time, and returns the number of
+paragraphs that were updated. Tokens are counted with SplitWords, as they are
+when paragraphs are stored. Until they have been backfilled, those paragraphs
+don't add any tokens to the corpus stats. As with BackfillSearchVectors, each
+batch is committed on its own, and paragraphs without a body are left without
+a token count.
+*/
+func (p PostgresStorage) BackfillTokenCounts(batchSize int) (int, error) {
+  return backfillInBatches(batchSize, p.backfillTokenCountBatch)
+}
+
+/*
+backfillTokenCountBatch counts the tokens of at most limit paragraphs without a
+token count whose ids are greater than afterId. It returns the number of
+paragraphs counted and the largest id in the batch, which is 0 once there are
+no paragraphs left.
+*/
+func (p PostgresStorage) backfillTokenCountBatch(afterId, limit int) (int, int, error) {
+  ctx := p.Context()
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
+    return 0, 0, err
+  }
+
+  bodies, batchEnd, err := unfilledParagraphs(ctx, txn, "token_count", afterId, limit)
+  if err != nil {
+    txn.Rollback()
+    return 0, 0, err
+  }
+
+  for id, body := range bodies {
+    _, err = txn.ExecContext(ctx, `UPDATE paragraphs SET token_count = $1 WHERE id = $2`,
+      len(SplitWords(body)), id)
+    if err != nil {
+      txn.Rollback()
+      return 0, 0, err
+    }
+  }
+
+  return len(bodies), batchEnd, txn.Commit()
+}
+
+/*
+unfilledParagraphs locks at most limit paragraphs whose column is NULL and
+whose ids are greater than afterId, and returns the bodies of those with a body
+keyed by their ids, along with the largest id of the locked paragraphs.
+*/
+func unfilledParagraphs(ctx context.Context, txn *sql.Tx, column string, afterId, limit int) (map[int]string, int, error) {
+  rows, err := txn.QueryContext(ctx,
+    `SELECT id, body FROM paragraphs
+      WHERE ` + column + ` IS NULL AND id > $1
+      ORDER BY id
+      LIMIT $2
+      FOR UPDATE`, afterId, limit)
+  if err != nil {
+    return nil, 0, err
+  }
+  defer rows.Close()
+
+  bodies := make(map[int]string)
+  batchEnd := 0
+  for rows.Next() {
+    var id int
+    var body sql.NullString
+    err = rows.Scan(&id, &body)
+    if err != nil {
+      return nil, 0, err
+    }
+    if body.Valid {
+      bodies[id] = body.String
+    }
+    batchEnd = id
+  }
+
+  return bodies, batchEnd, rows.Err()
+}
@@ -2,7 +2,10 @@ package philarios
 
 import (
   "database/sql"
+  "reflect"
   "testing"
+
+  "github.com/wangjohn/updike/migration"
 )
 
 const (
@@ -16,6 +19,8 @@ func teardownDatabase(db *sql.DB) {
     DROP TABLE IF EXISTS categories;
     DROP TABLE IF EXISTS publications;
     DROP TABLE IF EXISTS frequencies;
+    DROP TABLE IF EXISTS schema_version;
+    DROP FUNCTION IF EXISTS paragraphs_body_tsv_update();
   `)
 }
 
@@ -25,8 +30,12 @@ func setupDatabase() (Storage, error) {
     return nil, err
   }
 
-  philariosDatabase := PostgresStorage{db}
+  philariosDatabase := PostgresStorage{SQLDatabase: db}
   teardownDatabase(db)
+  err = philariosDatabase.Migrate()
+  if err != nil {
+    return philariosDatabase, err
+  }
 
   publication := Publication{
     Title: "Great Expectations",
@@ -83,3 +92,579 @@ func TestCreatingAndQueryingPostgresDatabaseWithoutCategories(t *testing.T) {
     }
   }
 }
+
+func TestQueryingPostgresDatabaseWithCategories(t *testing.T) {
+  philariosDatabase, err := setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Options QueryOptions
+    ExpectedParagraphs int
+  }{
+    {QueryOptions{Categories: []string{"dickens"}}, 2},
+    {QueryOptions{Categories: []string{"wikipedia_article"}}, 0},
+    {QueryOptions{Categories: []string{"wikipedia_article", "classic"}}, 2},
+    {QueryOptions{Categories: []string{"classic", "dickens"}, MatchAllCategories: true}, 2},
+    {QueryOptions{Categories: []string{"wikipedia_article", "classic"}, MatchAllCategories: true}, 0},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := philariosDatabase.QueryForWordWithOptions("Georgiana", fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs with options %+v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Options, len(paragraphs))
+    }
+  }
+}
+
+func TestUpsertingPublicationInPostgresDatabase(t *testing.T) {
+  db, err := sql.Open(testDriverName, testDataSourceName)
+  if err != nil {
+    t.Errorf("Error opening database: %s", err.Error())
+  }
+  teardownDatabase(db)
+
+  philariosDatabase := PostgresStorage{SQLDatabase: db}
+  err = philariosDatabase.Migrate()
+  if err != nil {
+    t.Errorf("Error migrating database: %s", err.Error())
+  }
+
+  publication := Publication{
+    SourceID: "pip",
+    Categories: []string{"classic"},
+    Text: "Pip, sir.",
+  }
+
+  err = philariosDatabase.UpsertPublication(publication)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when upserting a publication: %s", err.Error())
+  }
+
+  publication.Categories = []string{"dickens"}
+  publication.Text = "Once more, said the man."
+  err = philariosDatabase.UpsertPublication(publication)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when replacing a publication: %s", err.Error())
+  }
+
+  var publications, categories, paragraphs int
+  err = db.QueryRow(`SELECT
+      (SELECT COUNT(*) FROM publications),
+      (SELECT COUNT(*) FROM categories),
+      (SELECT COUNT(*) FROM paragraphs)`).Scan(&publications, &categories, &paragraphs)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when counting rows: %s", err.Error())
+  }
+
+  if publications != 1 || categories != 1 || paragraphs != 1 {
+    t.Errorf("Should have obtained a single publication, category and paragraph, instead obtained %d, %d and %d",
+      publications, categories, paragraphs)
+  }
+
+  matches, err := philariosDatabase.QueryForWordWithOptions("man", QueryOptions{Categories: []string{"dickens"}})
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+  if len(matches) != 1 {
+    t.Errorf("Should have obtained 1 paragraph from the replaced publication, instead obtained %d", len(matches))
+  }
+}
+
+func TestMigratingDuplicateSourceIDsInPostgresDatabase(t *testing.T) {
+  db, err := sql.Open(testDriverName, testDataSourceName)
+  if err != nil {
+    t.Errorf("Error opening database: %s", err.Error())
+  }
+  teardownDatabase(db)
+
+  err = migration.Migrate(db, storageMigrationComponent, storageMigrations[:1])
+  if err != nil {
+    t.Errorf("Error creating the original schema: %s", err.Error())
+  }
+
+  // Publications 2 and 4 duplicate the source ids of publications 1 and 3,
+  // and publications 5 and 6 are empty.
+  _, err = db.Exec(`
+    INSERT INTO publications (id, source_id) VALUES
+      (1, 'pip'), (2, 'pip'), (3, 'twice'), (4, 'twice'), (5, 'empty'), (6, 'blank');
+    INSERT INTO categories (publication, category) VALUES
+      (3, 'classic'), (4, 'classic'), (5, 'classic');
+    INSERT INTO paragraphs (publication, body) VALUES
+      (1, 'Pip, sir.'), (3, 'Once more.'), (4, 'Once more.');
+  `)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when inserting publications: %s", err.Error())
+  }
+
+  err = PostgresStorage{SQLDatabase: db}.Migrate()
+  if err != nil {
+    t.Errorf("Error migrating database: %s", err.Error())
+  }
+
+  var publications string
+  var categories, paragraphs int
+  err = db.QueryRow(`SELECT
+      (SELECT string_agg(id::text, ',' ORDER BY id) FROM publications),
+      (SELECT COUNT(*) FROM categories),
+      (SELECT COUNT(*) FROM paragraphs)`).Scan(&publications, &categories, &paragraphs)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when counting rows: %s", err.Error())
+  }
+
+  if publications != "1,3,5,6" || categories != 2 || paragraphs != 2 {
+    t.Errorf("Should have kept publications 1,3,5,6 with 2 categories and 2 paragraphs, instead kept %s with %d and %d",
+      publications, categories, paragraphs)
+  }
+}
+
+func TestBackfillingSearchVectorsInPostgresDatabase(t *testing.T) {
+  db, err := sql.Open(testDriverName, testDataSourceName)
+  if err != nil {
+    t.Errorf("Error opening database: %s", err.Error())
+  }
+
+  _, err = setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  philariosDatabase := PostgresStorage{SQLDatabase: db}
+  _, err = db.Exec(`UPDATE paragraphs SET body_tsv = NULL`)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when clearing search vectors: %s", err.Error())
+  }
+
+  // A paragraph without a body can never be given a search vector, and must
+  // not stop the backfill from finishing.
+  _, err = db.Exec(`INSERT INTO paragraphs (publication, position, token_count, body)
+    VALUES (1, 100, 0, NULL)`)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a paragraph without a body: %s", err.Error())
+  }
+
+  _, err = philariosDatabase.BackfillSearchVectors(0)
+  if err == nil {
+    t.Errorf("Should have thrown an error when backfilling with an empty batch size")
+  }
+
+  paragraphs, err := philariosDatabase.QueryForWord("Georgiana", nil)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+  if len(paragraphs) != 0 {
+    t.Errorf("Should not have found paragraphs without search vectors, instead obtained %d", len(paragraphs))
+  }
+
+  updated, err := philariosDatabase.BackfillSearchVectors(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when backfilling search vectors: %s", err.Error())
+  }
+
+  var expectedUpdated int
+  db.QueryRow(`SELECT COUNT(*) FROM paragraphs WHERE body IS NOT NULL`).Scan(&expectedUpdated)
+  if updated != expectedUpdated {
+    t.Errorf("Should have backfilled %d paragraphs, instead backfilled %d", expectedUpdated, updated)
+  }
+
+  paragraphs, err = philariosDatabase.QueryForWord("Georgiana", nil)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+  if len(paragraphs) != 2 {
+    t.Errorf("Should have obtained 2 paragraphs after backfilling, instead obtained %d", len(paragraphs))
+  }
+}
+
+func TestBackfillingPositionsInPostgresDatabase(t *testing.T) {
+  db, err := sql.Open(testDriverName, testDataSourceName)
+  if err != nil {
+    t.Errorf("Error opening database: %s", err.Error())
+  }
+
+  _, err = setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  philariosDatabase := PostgresStorage{SQLDatabase: db}
+  _, err = db.Exec(`UPDATE paragraphs SET position = NULL`)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when clearing positions: %s", err.Error())
+  }
+
+  paragraphs, err := philariosDatabase.PublicationParagraphs(1)
+  if err != nil || len(paragraphs) < 2 {
+    t.Errorf("Should have obtained the paragraphs of publication 1, instead obtained %v (err=%v)", paragraphs, err)
+    return
+  }
+  neighbors, err := philariosDatabase.NeighboringParagraphs(paragraphs[0].ID, 1)
+  if err != nil || len(neighbors) != 1 || neighbors[0].Position != -1 {
+    t.Errorf("Should have obtained only the unnumbered paragraph itself, instead obtained %v (err=%v)", neighbors, err)
+  }
+
+  _, err = philariosDatabase.BackfillPositions(0)
+  if err == nil {
+    t.Errorf("Should have thrown an error when backfilling with an empty batch size")
+  }
+
+  updated, err := philariosDatabase.BackfillPositions(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when backfilling positions: %s", err.Error())
+  }
+  if updated != len(paragraphs) {
+    t.Errorf("Should have backfilled %d paragraphs, instead backfilled %d", len(paragraphs), updated)
+  }
+
+  backfilled, err := philariosDatabase.PublicationParagraphs(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when getting paragraphs: %s", err.Error())
+  }
+  for i, paragraph := range backfilled {
+    if paragraph.ID != paragraphs[i].ID || paragraph.Position != i {
+      t.Errorf("Should have numbered paragraph %d at position %d, instead obtained %+v", paragraphs[i].ID, i, paragraph)
+    }
+  }
+  neighbors, err = philariosDatabase.NeighboringParagraphs(paragraphs[0].ID, 1)
+  if err != nil || len(neighbors) != 2 {
+    t.Errorf("Should have obtained 2 paragraphs after backfilling, instead obtained %v (err=%v)", neighbors, err)
+  }
+}
+
+func TestBackfillingTokenCountsInPostgresDatabase(t *testing.T) {
+  db, err := sql.Open(testDriverName, testDataSourceName)
+  if err != nil {
+    t.Errorf("Error opening database: %s", err.Error())
+  }
+
+  _, err = setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  philariosDatabase := PostgresStorage{SQLDatabase: db}
+  expected, err := philariosDatabase.CorpusStats()
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when obtaining corpus stats: %s", err.Error())
+  }
+
+  _, err = db.Exec(`UPDATE paragraphs SET token_count = NULL`)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when clearing token counts: %s", err.Error())
+  }
+  _, err = db.Exec(`INSERT INTO paragraphs (publication, position, body)
+    VALUES (1, 100, NULL)`)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a paragraph without a body: %s", err.Error())
+  }
+
+  _, err = philariosDatabase.BackfillTokenCounts(0)
+  if err == nil {
+    t.Errorf("Should have thrown an error when backfilling with an empty batch size")
+  }
+
+  stats, _ := philariosDatabase.CorpusStats()
+  if stats.Tokens != 0 {
+    t.Errorf("Should have counted no tokens before backfilling, instead counted %d", stats.Tokens)
+  }
+
+  updated, err := philariosDatabase.BackfillTokenCounts(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when backfilling token counts: %s", err.Error())
+  }
+  if updated != expected.Paragraphs {
+    t.Errorf("Should have backfilled %d paragraphs, instead backfilled %d", expected.Paragraphs, updated)
+  }
+
+  stats, _ = philariosDatabase.CorpusStats()
+  if stats.Tokens != expected.Tokens {
+    t.Errorf("Should have counted %d tokens after backfilling, instead counted %d", expected.Tokens, stats.Tokens)
+  }
+}
+
+func TestBackfillingContentHashesInPostgresDatabase(t *testing.T) {
+  db, err := sql.Open(testDriverName, testDataSourceName)
+  if err != nil {
+    t.Errorf("Error opening database: %s", err.Error())
+  }
+
+  _, err = setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  philariosDatabase := PostgresStorage{SQLDatabase: db}
+  err = philariosDatabase.AddPublication(Publication{SourceID: "reprint", Text: "\"Pip, sir.\""})
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  _, err = db.Exec(`UPDATE paragraphs SET content_hash = NULL, duplicate = false`)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when clearing content hashes: %s", err.Error())
+  }
+  _, err = db.Exec(`INSERT INTO paragraphs (publication, position, token_count, body)
+    VALUES (1, 100, 0, NULL)`)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a paragraph without a body: %s", err.Error())
+  }
+
+  _, err = philariosDatabase.BackfillContentHashes(0)
+  if err == nil {
+    t.Errorf("Should have thrown an error when backfilling with an empty batch size")
+  }
+
+  paragraphs, _ := philariosDatabase.QueryForWord("pip", nil)
+  if len(paragraphs) != 5 {
+    t.Errorf("Should have obtained 5 paragraphs before backfilling, instead obtained %d", len(paragraphs))
+  }
+
+  updated, err := philariosDatabase.BackfillContentHashes(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when backfilling content hashes: %s", err.Error())
+  }
+
+  var expectedUpdated int
+  db.QueryRow(`SELECT COUNT(*) FROM paragraphs WHERE body IS NOT NULL`).Scan(&expectedUpdated)
+  if updated != expectedUpdated {
+    t.Errorf("Should have backfilled %d paragraphs, instead backfilled %d", expectedUpdated, updated)
+  }
+
+  paragraphs, _ = philariosDatabase.QueryForWord("pip", nil)
+  if len(paragraphs) != 4 {
+    t.Errorf("Should have obtained 4 paragraphs after backfilling, instead obtained %d", len(paragraphs))
+  }
+}
+
+func TestQueryingPostgresDatabaseByLanguage(t *testing.T) {
+  philariosDatabase, err := setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  err = philariosDatabase.AddPublication(Publication{
+    SourceID: "french",
+    Language: "french",
+    Text: "La rivière passait près des marais, où Georgiana était enterrée.",
+  })
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Language string
+    ExpectedParagraphs int
+  }{
+    {"Georgiana", "", 2},
+    {"Georgiana", "french", 1},
+    {"rivières", "french", 1},
+    {"Georgiana's (mother) & father", "", 0},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := philariosDatabase.QueryForWordWithOptions(fixture.Word, QueryOptions{Language: fixture.Language})
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for %q: %s", fixture.Word, err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q in %q, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Language, len(paragraphs))
+    }
+  }
+}
+
+func TestQueryingPostgresDatabaseForPhrases(t *testing.T) {
+  philariosDatabase, err := setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Words []string
+    ExpectedParagraphs int
+  }{
+    {[]string{"family", "name"}, 2},
+    {[]string{"name", "family"}, 0},
+    {[]string{"Georgiana", "wife", "of", "the", "above"}, 2},
+    {[]string{"hold", "your", "noise"}, 1},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := philariosDatabase.QueryForPhrase(fixture.Words, nil)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for phrase: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Words, len(paragraphs))
+    }
+  }
+}
+
+func TestQueryingPostgresDatabaseByAuthorAndDate(t *testing.T) {
+  philariosDatabase, err := setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    ExpectedParagraphs int
+  }{
+    {"pip", QueryOptions{Authors: []string{"Charles Dickens"}}, 4},
+    {"pip", QueryOptions{Authors: []string{"wikipedia"}}, 0},
+    {"pip", QueryOptions{StartDate: "1800-01-01", EndDate: "1900-12-31"}, 4},
+    {"pip", QueryOptions{StartDate: "1860-01-13"}, 0},
+    {"pip", QueryOptions{EndDate: "1860-01-11"}, 0},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := philariosDatabase.QueryForWordWithOptions(fixture.Word, fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q with options %+v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Options, len(paragraphs))
+    }
+  }
+}
+
+func TestDeduplicatingParagraphsInPostgresDatabase(t *testing.T) {
+  philariosDatabase, err := setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  err = philariosDatabase.AddPublication(Publication{
+    SourceID: "reprint",
+    Author: "wikipedia",
+    Categories: []string{"reprint"},
+    Text: "\"Pip, sir.\"\nPIP. PIP, SIR.",
+  })
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+  }
+
+  fixtures := []struct {
+    Word string
+    Options QueryOptions
+    ExpectedParagraphs int
+  }{
+    {"sir", QueryOptions{}, 3},
+    {"sir", QueryOptions{IncludeDuplicates: true}, 5},
+    {"sir", QueryOptions{Categories: []string{"reprint"}}, 2},
+    {"sir", QueryOptions{Authors: []string{"wikipedia"}}, 2},
+    {"sir", QueryOptions{Categories: []string{"reprint"}, IncludeDuplicates: true}, 2},
+    {"sir", QueryOptions{Ranked: true, Limit: 3}, 3},
+  }
+
+  for _, fixture := range fixtures {
+    paragraphs, err := philariosDatabase.QueryForWordWithOptions(fixture.Word, fixture.Options)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+    }
+
+    if len(paragraphs) != fixture.ExpectedParagraphs {
+      t.Errorf("Should have obtained %d paragraphs for %q with options %+v, instead obtained %d",
+        fixture.ExpectedParagraphs, fixture.Word, fixture.Options, len(paragraphs))
+    }
+  }
+
+  stats, err := philariosDatabase.CorpusStats()
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when obtaining corpus stats: %s", err.Error())
+  }
+  if stats.DuplicateParagraphs != 2 {
+    t.Errorf("Should have counted 2 duplicate paragraphs, instead counted %d", stats.DuplicateParagraphs)
+  }
+
+  for _, sourceID := range []string{"original", "copy"} {
+    err = philariosDatabase.AddPublication(Publication{SourceID: sourceID, Text: "Once more, said the man."})
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when adding a publication: %s", err.Error())
+    }
+  }
+  _, err = philariosDatabase.RemovePublication("original")
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when removing a publication: %s", err.Error())
+  }
+  paragraphs, err := philariosDatabase.QueryForWord("man", nil)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when querying for word: %s", err.Error())
+  }
+  if len(paragraphs) != 1 {
+    t.Errorf("Should have obtained the remaining duplicate once its original was removed, instead obtained %v", paragraphs)
+  }
+}
+
+func TestPublicationCatalogInPostgresDatabase(t *testing.T) {
+  philariosDatabase, err := setupDatabase()
+  if err != nil {
+    t.Errorf("Error setting up database and seeding with data: %s", err.Error())
+  }
+
+  publication, err := philariosDatabase.GetPublication(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when getting a publication: %s", err.Error())
+  }
+  if publication.Title != "Great Expectations" || publication.Date != "1860-01-12" ||
+    !reflect.DeepEqual(publication.Categories, []string{"classic", "dickens"}) {
+    t.Errorf("Obtained an unexpected publication: %+v", publication)
+  }
+
+  _, err = philariosDatabase.GetPublicationBySourceID("nonexistent")
+  if err != ErrPublicationNotFound {
+    t.Errorf("Should have obtained ErrPublicationNotFound for a missing publication, instead obtained %v", err)
+  }
+
+  paragraphs, err := philariosDatabase.PublicationParagraphs(1)
+  if err != nil {
+    t.Errorf("Shouldn't have thrown an error when obtaining paragraphs: %s", err.Error())
+  }
+  for i, paragraph := range paragraphs {
+    if paragraph.PublicationId != 1 || paragraph.Position != i {
+      t.Errorf("Obtained an unexpected paragraph at position %d: %+v", i, paragraph)
+    }
+  }
+
+  _, err = philariosDatabase.PublicationParagraphs(100)
+  if err != ErrPublicationNotFound {
+    t.Errorf("Should have obtained ErrPublicationNotFound for a missing publication, instead obtained %v", err)
+  }
+
+  fixtures := []struct {
+    Filter PublicationFilter
+    ExpectedPublications int
+  }{
+    {PublicationFilter{}, 1},
+    {PublicationFilter{Author: "Charles Dickens", Category: "classic"}, 1},
+    {PublicationFilter{Type: "wikipedia_article"}, 0},
+    {PublicationFilter{StartDate: "1860-01-01", EndDate: "1860-12-31"}, 1},
+    {PublicationFilter{Offset: 1}, 0},
+    {PublicationFilter{AfterID: 1}, 0},
+  }
+
+  for _, fixture := range fixtures {
+    publications, err := philariosDatabase.ListPublications(fixture.Filter)
+    if err != nil {
+      t.Errorf("Shouldn't have thrown an error when listing publications: %s", err.Error())
+    }
+
+    if len(publications) != fixture.ExpectedPublications {
+      t.Errorf("Should have listed %d publications with filter %+v, instead listed %d",
+        fixture.ExpectedPublications, fixture.Filter, len(publications))
+    }
+  }
+}
@@ -1,6 +1,8 @@
 package philarios
 
 import (
+  "crypto/sha1"
+  "encoding/hex"
   "strings"
   "unicode"
 )
@@ -26,3 +28,18 @@ func FuzzyStringEquals(word1, word2 string) (bool) {
 func CanonicalWordForm(word string) (string) {
   return strings.TrimSpace(strings.ToLower(word))
 }
+
+/*
+ContentHash returns a hash of the text which ignores differences of case,
+punctuation and spacing, so that paragraphs which only differ in those ways
+have the same hash.
+*/
+func ContentHash(text string) (string) {
+  words := SplitWords(text)
+  for i, word := range words {
+    words[i] = CanonicalWordForm(word)
+  }
+
+  hash := sha1.Sum([]byte(strings.Join(words, " ")))
+  return hex.EncodeToString(hash[:])
+}
@@ -24,3 +24,24 @@ func TestSplitWords(t *testing.T) {
     }
   }
 }
+
+func TestContentHash(t *testing.T) {
+  fixtures := []struct {
+    text1 string
+    text2 string
+    expectedEqual bool
+  }{
+    {"Pip, sir.", "pip sir", true},
+    {"  \"Pip. Pip, sir.\"", "Pip... pip -- SIR", true},
+    {"Pip, sir.", "Pip, sir, Pip.", false},
+    {"Pip sir", "Pipsir", false},
+  }
+
+  for _, fixture := range fixtures {
+    equal := ContentHash(fixture.text1) == ContentHash(fixture.text2)
+    if equal != fixture.expectedEqual {
+      t.Errorf("Content hashes of %q and %q should be equal: %t, but were equal: %t",
+        fixture.text1, fixture.text2, fixture.expectedEqual, equal)
+    }
+  }
+}
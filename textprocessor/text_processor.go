@@ -37,27 +37,67 @@ func NormalizedWord(word string) (string, error) {
   return word, nil
 }
 
+/*
+ParagraphSpan is a paragraph of a text, along with its position in the text.
+Start is the byte offset of the paragraph's first byte in the original text,
+and End is the byte offset just after its last byte.
+*/
+type ParagraphSpan struct {
+  Text string
+  Start int
+  End int
+}
+
+/*
+ProcessParagraphs splits a publication's text into its paragraphs, which are
+separated by newlines. Surrounding whitespace is removed from each paragraph,
+and empty paragraphs are dropped.
+*/
 func ProcessParagraphs(text string) ([]string, error) {
   var paragraphs []string
-  preprocessedText, err := preprocessPublicationText(text)
+  spans, err := ProcessParagraphSpans(text)
   if err != nil {
     return paragraphs, err
   }
 
-  preprocessedParagraphs := []string{preprocessedText}
-  var postprocessedParagraph string
-  for _, preprocessedParagraph := range preprocessedParagraphs {
-    postprocessedParagraph, err = postprocessParagraph(preprocessedParagraph)
+  for _, span := range spans {
+    paragraphs = append(paragraphs, span.Text)
+  }
+
+  return paragraphs, nil
+}
+
+/*
+ProcessParagraphSpans splits a publication's text into its paragraphs in the
+same way as ProcessParagraphs, and records where each paragraph lies in the
+text.
+*/
+func ProcessParagraphSpans(text string) ([]ParagraphSpan, error) {
+  var spans []ParagraphSpan
+  preprocessedText, err := preprocessPublicationText(text)
+  if err != nil {
+    return spans, err
+  }
+
+  lineStart := 0
+  for _, preprocessedParagraph := range strings.Split(preprocessedText, "\n") {
+    postprocessedParagraph, err := postprocessParagraph(preprocessedParagraph)
     if err != nil {
-      return paragraphs, err
+      return spans, err
     }
 
     if postprocessedParagraph != "" {
-      paragraphs = append(paragraphs, postprocessedParagraph)
+      start := lineStart + strings.Index(preprocessedParagraph, postprocessedParagraph)
+      spans = append(spans, ParagraphSpan{
+        Text: postprocessedParagraph,
+        Start: start,
+        End: start + len(postprocessedParagraph),
+      })
     }
+    lineStart += len(preprocessedParagraph) + 1
   }
 
-  return paragraphs, nil
+  return spans, nil
 }
 
 func preprocessPublicationText(text string) (string, error) {
@@ -16,6 +16,10 @@ func TestProcessParagraphs(t *testing.T) {
       []string{"Blah"}},
     {"\n\nAlmost\nThere\n\nAreyou? Sure  \n",
       []string{"Almost", "There", "Areyou? Sure"}},
+    {"  A text without newlines is a single paragraph.  ",
+      []string{"A text without newlines is a single paragraph."}},
+    {"\n  \n",
+      []string{}},
   }
 
   for _, fixture := range fixtures {
@@ -38,6 +42,40 @@ func TestProcessParagraphs(t *testing.T) {
   }
 }
 
+func TestProcessParagraphSpans(t *testing.T) {
+  fixtures := []struct {
+    Text string
+    Expected []ParagraphSpan
+  }{
+    {"This is some\nText",
+      []ParagraphSpan{{"This is some", 0, 12}, {"Text", 13, 17}}},
+    {"\n\n  Blah  \n",
+      []ParagraphSpan{{"Blah", 4, 8}}},
+    {"Pip, sir.\n\n\u2014Once more",
+      []ParagraphSpan{{"Pip, sir.", 0, 9}, {"\u2014Once more", 11, 23}}},
+    {" Pip, sir. Once more. ",
+      []ParagraphSpan{{"Pip, sir. Once more.", 1, 21}}},
+  }
+
+  for _, fixture := range fixtures {
+    spans, err := ProcessParagraphSpans(fixture.Text)
+    if err != nil {
+      t.Errorf("Did not expect error for ProcessParagraphSpans: %s", err.Error())
+    }
+
+    if !reflect.DeepEqual(spans, fixture.Expected) {
+      t.Errorf("Unexpected paragraph spans. Expected %v, but obtained %v",
+        fixture.Expected, spans)
+    }
+
+    for _, span := range spans {
+      if fixture.Text[span.Start:span.End] != span.Text {
+        t.Errorf("Span offsets [%d, %d) do not point at paragraph '%s'", span.Start, span.End, span.Text)
+      }
+    }
+  }
+}
+
 func TestGetSlice(t *testing.T) {
   fixtures := []struct {
     Word string
@@ -0,0 +1,139 @@
+package tfidf
+
+import (
+  "sync"
+)
+
+/*
+corpusCounts is the number of documents in a corpus, and the total number of
+words in those documents.
+*/
+type corpusCounts struct {
+  documents int
+  words int
+}
+
+/*
+averageLength returns the average number of words in the documents, or zero
+if there are none.
+*/
+func (c corpusCounts) averageLength() (float64) {
+  if c.documents == 0 {
+    return 0.0
+  }
+  return float64(c.words) / float64(c.documents)
+}
+
+/*
+corpusSize caches the counts of the corpus of a PersistentTFIDF. Documents
+stored and removed through the TFIDF adjust the cached counts. Each adjustment
+increments its generation, so that counts which were running during an
+adjustment aren't cached. The methods of a nil corpusSize do nothing, so that
+a PersistentTFIDF without one counts the documents on each call.
+*/
+type corpusSize struct {
+  sync.Mutex
+  counts corpusCounts
+  loaded bool
+  generation int
+}
+
+/*
+get returns the cached counts, if they are loaded, along with the current
+generation.
+*/
+func (c *corpusSize) get() (corpusCounts, int, bool) {
+  if c == nil {
+    return corpusCounts{}, 0, false
+  }
+
+  c.Lock()
+  defer c.Unlock()
+  return c.counts, c.generation, c.loaded
+}
+
+/*
+set caches the counts made in the given generation, unless they have been
+adjusted since.
+*/
+func (c *corpusSize) set(counts corpusCounts, generation int) {
+  if c == nil {
+    return
+  }
+
+  c.Lock()
+  defer c.Unlock()
+  if c.generation == generation {
+    c.counts = counts
+    c.loaded = true
+  }
+}
+
+/*
+add adjusts the cached counts by the numbers of documents and words added.
+*/
+func (c *corpusSize) add(documents, words int) {
+  if c == nil || (documents == 0 && words == 0) {
+    return
+  }
+
+  c.Lock()
+  defer c.Unlock()
+  c.generation++
+  c.counts.documents += documents
+  c.counts.words += words
+}
+
+/*
+invalidate discards the cached counts, so that they are counted again.
+*/
+func (c *corpusSize) invalidate() {
+  if c == nil {
+    return
+  }
+
+  c.Lock()
+  defer c.Unlock()
+  c.generation++
+  c.loaded = false
+}
+
+/*
+TotalDocuments returns the number of documents in the corpus. The number is
+cached, and kept up to date with the documents stored and removed through the
+TFIDF and its copies, but documents stored by other TFIDFs using the same
+database aren't counted until RefreshCorpusSize is called.
+*/
+func (p PersistentTFIDF) TotalDocuments() (int, error) {
+  counts, err := p.size()
+  return counts.documents, err
+}
+
+/*
+size returns the counts of the corpus, from the cache if they are cached.
+*/
+func (p PersistentTFIDF) size() (corpusCounts, error) {
+  counts, generation, loaded := p.corpusSize.get()
+  if loaded {
+    return counts, nil
+  }
+
+  err := p.SQLDatabase.QueryRowContext(p.Context(),
+    `SELECT COUNT(*), COALESCE(SUM(length), 0) FROM documents`).Scan(&counts.documents, &counts.words)
+  if err != nil {
+    return corpusCounts{}, err
+  }
+
+  p.corpusSize.set(counts, generation)
+  return counts, nil
+}
+
+/*
+RefreshCorpusSize counts the documents in the corpus again, including those
+stored by other TFIDFs or processes using the same database.
+*/
+func (p PersistentTFIDF) RefreshCorpusSize() (error) {
+  p.corpusSize.invalidate()
+  _, err := p.TotalDocuments()
+  return err
+}
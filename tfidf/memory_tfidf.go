@@ -0,0 +1,384 @@
+package tfidf
+
+import (
+  "github.com/reiver/go-porterstemmer"
+
+  "context"
+  "encoding/gob"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+)
+
+/*
+MemoryTFIDF is an implementation of TFIDF which keeps the term and document
+frequencies of words in memory, so its scores can be computed without a round
+trip to PersistentTFIDF's tables. Since its index grows with the vocabulary of
+every stored document, it can be snapshotted to disk rather than being
+rebuilt from the documents on every run. A MemoryTFIDF should be created with
+NewMemoryTFIDF or LoadMemoryTFIDF, and copies of it share the same underlying
+index. Its methods are safe to call concurrently. Words are weighed by the
+Weighting scheme, which is the default scheme unless it is set.
+*/
+type MemoryTFIDF struct {
+  index *memoryTFIDFIndex
+  Weighting WeightingScheme
+  ctx context.Context
+}
+
+type memoryTFIDFIndex struct {
+  sync.RWMutex
+  documents map[int]*memoryDocument
+  documentFrequency map[string]int
+  words int
+}
+
+/*
+memoryDocument holds the frequencies of the words stored for a document.
+MaxWordFreq is the most recently stored maximum word frequency of the
+document, which is used for the words that the document doesn't contain. The
+length of the document is the sum of the frequencies of its words, so it isn't
+written to snapshots.
+*/
+type memoryDocument struct {
+  MaxWordFreq int
+  Words map[string]wordFrequency
+  length int
+}
+
+type wordFrequency struct {
+  Freq int
+  DocMaxWordFreq int
+}
+
+/*
+NewMemoryTFIDF returns an empty MemoryTFIDF.
+*/
+func NewMemoryTFIDF() (MemoryTFIDF) {
+  return MemoryTFIDF{index: newMemoryTFIDFIndex(make(map[int]*memoryDocument))}
+}
+
+func newMemoryTFIDFIndex(documents map[int]*memoryDocument) (*memoryTFIDFIndex) {
+  index := &memoryTFIDFIndex{
+    documents: documents,
+    documentFrequency: make(map[string]int),
+  }
+  for _, document := range documents {
+    document.length = 0
+    for word, frequency := range document.Words {
+      index.documentFrequency[word]++
+      document.length += frequency.Freq
+    }
+    index.words += document.length
+  }
+  return index
+}
+
+/*
+WithContext returns a copy of the TFIDF which checks ctx in each of its calls.
+The copy shares the same index.
+*/
+func (m MemoryTFIDF) WithContext(ctx context.Context) (TFIDF) {
+  m.ctx = ctx
+  return m
+}
+
+/*
+Context returns the context that the TFIDF's calls check, which is
+context.Background() unless one was given to WithContext.
+*/
+func (m MemoryTFIDF) Context() (context.Context) {
+  if m.ctx == nil {
+    return context.Background()
+  }
+  return m.ctx
+}
+
+func (m MemoryTFIDF) TermFrequency(word string, documentId int) (float64, error) {
+  err := m.Context().Err()
+  if err != nil {
+    return 0.0, err
+  }
+
+  word, err = m.NormalizeWord(word)
+  if err != nil {
+    return 0.0, err
+  }
+
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  document, exists := m.index.documents[documentId]
+  if !exists {
+    return 0.0, fmt.Errorf("Document with id=%v does not exist", documentId)
+  }
+
+  counts := TermCounts{DocMaxWordFreq: document.MaxWordFreq}
+  frequency, exists := document.Words[word]
+  if exists {
+    counts = TermCounts{Freq: frequency.Freq, DocMaxWordFreq: frequency.DocMaxWordFreq}
+  }
+  if m.Weighting.DocumentLengths {
+    counts.DocLength = document.length
+    counts.AvgDocLength = float64(m.index.words) / float64(len(m.index.documents))
+  }
+
+  return m.Weighting.termFrequency(counts), nil
+}
+
+func (m MemoryTFIDF) InverseDocumentFrequency(word string) (float64, error) {
+  err := m.Context().Err()
+  if err != nil {
+    return 0.0, err
+  }
+
+  word, err = m.NormalizeWord(word)
+  if err != nil {
+    return 0.0, err
+  }
+
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  return m.Weighting.inverseDocumentFrequency(m.index.documentFrequency[word], len(m.index.documents)), nil
+}
+
+func (m MemoryTFIDF) Score(word string, documentId int) (float64, error) {
+  word, err := m.NormalizeWord(word)
+  if err != nil {
+    return 0.0, err
+  }
+
+  tf, err := m.TermFrequency(word, documentId)
+  if err != nil {
+    return 0.0, err
+  }
+
+  idf, err := m.InverseDocumentFrequency(word)
+  if err != nil {
+    return 0.0, err
+  }
+
+  return tf * idf, nil
+}
+
+/*
+Store records the occurrences of a word in a document, replacing any
+occurrences stored for the same word and document before.
+*/
+func (m MemoryTFIDF) Store(word string, occurrences, docMaxWordOccurrences, documentId int) (error) {
+  err := m.Context().Err()
+  if err != nil {
+    return err
+  }
+
+  word, err = m.NormalizeWord(word)
+  if err != nil {
+    return err
+  }
+
+  m.index.Lock()
+  defer m.index.Unlock()
+
+  document, exists := m.index.documents[documentId]
+  if !exists {
+    document = &memoryDocument{Words: make(map[string]wordFrequency)}
+    m.index.documents[documentId] = document
+  }
+
+  previous, exists := document.Words[word]
+  if !exists {
+    m.index.documentFrequency[word]++
+  }
+  document.Words[word] = wordFrequency{occurrences, docMaxWordOccurrences}
+  document.MaxWordFreq = docMaxWordOccurrences
+  document.length += occurrences - previous.Freq
+  m.index.words += occurrences - previous.Freq
+
+  return nil
+}
+
+/*
+StoreDocument normalizes and counts the words of a document, and stores all of
+them at once. Anything stored for the document before is replaced.
+*/
+func (m MemoryTFIDF) StoreDocument(documentId int, words []string) (error) {
+  err := m.Context().Err()
+  if err != nil {
+    return err
+  }
+
+  counts, docMaxWordFreq, err := countWords(m, words)
+  if err != nil {
+    return err
+  }
+
+  m.index.Lock()
+  defer m.index.Unlock()
+
+  m.index.removeDocument(documentId)
+  if len(counts) == 0 {
+    return nil
+  }
+
+  document := &memoryDocument{
+    MaxWordFreq: docMaxWordFreq,
+    Words: make(map[string]wordFrequency, len(counts)),
+  }
+  for word, freq := range counts {
+    document.Words[word] = wordFrequency{freq, docMaxWordFreq}
+    document.length += freq
+    m.index.documentFrequency[word]++
+  }
+  m.index.documents[documentId] = document
+  m.index.words += document.length
+
+  return nil
+}
+
+/*
+RemoveDocument removes every word stored for a document, and decrements the
+number of unique documents of each of those words accordingly. Removing a
+document which doesn't exist does nothing.
+*/
+func (m MemoryTFIDF) RemoveDocument(documentId int) (error) {
+  err := m.Context().Err()
+  if err != nil {
+    return err
+  }
+
+  m.index.Lock()
+  defer m.index.Unlock()
+
+  m.index.removeDocument(documentId)
+  return nil
+}
+
+/*
+removeDocument removes a document from the index, which must be locked for
+writing.
+*/
+func (index *memoryTFIDFIndex) removeDocument(documentId int) {
+  document, exists := index.documents[documentId]
+  if !exists {
+    return
+  }
+
+  for word := range document.Words {
+    index.documentFrequency[word]--
+    if index.documentFrequency[word] <= 0 {
+      delete(index.documentFrequency, word)
+    }
+  }
+  delete(index.documents, documentId)
+  index.words -= document.length
+}
+
+func (m MemoryTFIDF) NormalizeWord(word string) (string, error) {
+  return porterstemmer.StemString(word), nil
+}
+
+/*
+snapshotVersion is the version of the format written by WriteSnapshot, which
+is increased whenever the format changes incompatibly.
+*/
+const snapshotVersion = 1
+
+type memoryTFIDFSnapshot struct {
+  Version int
+  Documents map[int]*memoryDocument
+}
+
+/*
+WriteSnapshot writes the words stored in the TFIDF to w, in a form which can
+be read back with ReadSnapshot.
+*/
+func (m MemoryTFIDF) WriteSnapshot(w io.Writer) (error) {
+  m.index.RLock()
+  defer m.index.RUnlock()
+
+  return gob.NewEncoder(w).Encode(memoryTFIDFSnapshot{
+    Version: snapshotVersion,
+    Documents: m.index.documents,
+  })
+}
+
+/*
+ReadSnapshot replaces the words stored in the TFIDF with those in a snapshot
+written by WriteSnapshot. The TFIDF is left unchanged if the snapshot can't be
+read.
+*/
+func (m MemoryTFIDF) ReadSnapshot(r io.Reader) (error) {
+  var snapshot memoryTFIDFSnapshot
+  err := gob.NewDecoder(r).Decode(&snapshot)
+  if err != nil {
+    return fmt.Errorf("Could not read TFIDF snapshot: %s", err.Error())
+  }
+  if snapshot.Version != snapshotVersion {
+    return fmt.Errorf("Unsupported TFIDF snapshot version %d, expected %d", snapshot.Version, snapshotVersion)
+  }
+
+  documents := snapshot.Documents
+  if documents == nil {
+    documents = make(map[int]*memoryDocument)
+  }
+  for _, document := range documents {
+    if document.Words == nil {
+      document.Words = make(map[string]wordFrequency)
+    }
+  }
+  index := newMemoryTFIDFIndex(documents)
+
+  m.index.Lock()
+  defer m.index.Unlock()
+  m.index.documents = index.documents
+  m.index.documentFrequency = index.documentFrequency
+  m.index.words = index.words
+
+  return nil
+}
+
+/*
+SaveSnapshot writes a snapshot of the TFIDF to the named file. The snapshot is
+written to a temporary file which then replaces the named file, so that the
+named file always holds a complete snapshot.
+*/
+func (m MemoryTFIDF) SaveSnapshot(filename string) (error) {
+  file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename) + ".tmp")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(file.Name())
+
+  err = m.WriteSnapshot(file)
+  if err != nil {
+    file.Close()
+    return err
+  }
+
+  err = file.Close()
+  if err != nil {
+    return err
+  }
+
+  return os.Rename(file.Name(), filename)
+}
+
+/*
+LoadMemoryTFIDF returns a MemoryTFIDF holding the snapshot in the named file,
+which was written by SaveSnapshot.
+*/
+func LoadMemoryTFIDF(filename string) (MemoryTFIDF, error) {
+  tfidf := NewMemoryTFIDF()
+
+  file, err := os.Open(filename)
+  if err != nil {
+    return tfidf, err
+  }
+  defer file.Close()
+
+  return tfidf, tfidf.ReadSnapshot(file)
+}
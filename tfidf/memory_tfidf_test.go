@@ -0,0 +1,239 @@
+package tfidf
+
+import (
+  "bytes"
+  "context"
+  "io/ioutil"
+  "math"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func setupMemoryTFIDF(t *testing.T) (MemoryTFIDF) {
+  tfidf := NewMemoryTFIDF()
+  storageFixtures := []struct {
+    Word string
+    Occurrences int
+    DocMaxWordOccurrences int
+    DocumentId int
+  }{
+    {"hello", 15, 43, 1},
+    {"tango", 32, 33, 2},
+    {"hello", 1, 50, 2},
+    {"blend", 3, 100, 1},
+  }
+
+  for _, f := range storageFixtures {
+    err := tfidf.Store(f.Word, f.Occurrences, f.DocMaxWordOccurrences, f.DocumentId)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to store words: err=%v", err)
+    }
+  }
+
+  return tfidf
+}
+
+func checkMemoryTFIDFScores(t *testing.T, tfidf TFIDF) {
+  scoreFixtures := []struct {
+    Word string
+    DocumentId int
+    ExpectedTF float64
+    ExpectedIDF float64
+    ExpectedScore float64
+  }{
+    {"hello", 1, 0.674418605, -0.176091259, -0.118759221},
+    {"hello", 2, 0.51, -0.176091259, -0.089806542},
+    {"tango", 2, 0.984848485, 0.0, 0.0},
+    {"blend", 1, 0.515, 0.0, 0.0},
+    {"notexistent", 1, 0.5, 0.3010299956, 0.150514998},
+    {"never existed before", 1, 0.5, 0.3010299956, 0.150514998},
+  }
+
+  for _, f := range scoreFixtures {
+    tfScore, err := tfidf.TermFrequency(f.Word, f.DocumentId)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to get Term Frequency: err=%v", err)
+    }
+    if math.Abs(tfScore - f.ExpectedTF) > floatEqualThresh {
+      t.Errorf("Received unexpected TF value: word=%v, result=%v, expected=%v",
+        f.Word, tfScore, f.ExpectedTF)
+    }
+
+    idfScore, err := tfidf.InverseDocumentFrequency(f.Word)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to get Inverse Document Frequency: err=%v", err)
+    }
+    if math.Abs(idfScore - f.ExpectedIDF) > floatEqualThresh {
+      t.Errorf("Received unexpected IDF value: word=%v, result=%v, expected=%v",
+        f.Word, idfScore, f.ExpectedIDF)
+    }
+
+    score, err := tfidf.Score(f.Word, f.DocumentId)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to get TFIDF score: err=%v", err)
+    }
+    if math.Abs(score - f.ExpectedScore) > floatEqualThresh {
+      t.Errorf("Received unexpected TFIDF value: word=%v, result=%v, expected=%v",
+        f.Word, score, f.ExpectedScore)
+    }
+  }
+}
+
+func TestMemoryTFIDFScores(t *testing.T) {
+  tfidf := setupMemoryTFIDF(t)
+  checkMemoryTFIDFScores(t, tfidf)
+
+  _, err := tfidf.TermFrequency("hello", 3)
+  if err == nil {
+    t.Errorf("Should have thrown an error for the term frequency of a nonexistent document")
+  }
+
+  // Storing a word again replaces its occurrences without counting the
+  // document twice.
+  err = tfidf.Store("hello", 43, 43, 1)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to store words: err=%v", err)
+  }
+  tfScore, _ := tfidf.TermFrequency("hello", 1)
+  idfScore, _ := tfidf.InverseDocumentFrequency("hello")
+  if math.Abs(tfScore - 1.0) > floatEqualThresh || math.Abs(idfScore - -0.176091259) > floatEqualThresh {
+    t.Errorf("Received unexpected scores after storing a word again: tf=%v, idf=%v", tfScore, idfScore)
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  _, err = tfidf.WithContext(ctx).Score("hello", 1)
+  if err != context.Canceled {
+    t.Errorf("Should have obtained context.Canceled with a cancelled context, instead obtained %v", err)
+  }
+}
+
+func TestMemoryTFIDFRemoveDocument(t *testing.T) {
+  tfidf := setupMemoryTFIDF(t)
+  err := tfidf.RemoveDocument(1)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to remove a document: err=%v", err)
+  }
+
+  fixtures := []struct {
+    Word string
+    ExpectedUniqueDocuments int
+  }{
+    {"hello", 1},
+    {"tango", 1},
+    {"blend", 0},
+  }
+
+  for _, f := range fixtures {
+    uniqueDocuments := tfidf.index.documentFrequency[f.Word]
+    if uniqueDocuments != f.ExpectedUniqueDocuments {
+      t.Errorf("Received unexpected unique documents: word=%v, result=%v, expected=%v",
+        f.Word, uniqueDocuments, f.ExpectedUniqueDocuments)
+    }
+  }
+
+  _, err = tfidf.TermFrequency("blend", 1)
+  if err == nil {
+    t.Errorf("Should have thrown an error for the term frequency of a removed document")
+  }
+}
+
+func TestMemoryTFIDFSnapshot(t *testing.T) {
+  var buffer bytes.Buffer
+  err := setupMemoryTFIDF(t).WriteSnapshot(&buffer)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to write a snapshot: err=%v", err)
+  }
+
+  restored := NewMemoryTFIDF()
+  err = restored.ReadSnapshot(&buffer)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to read a snapshot: err=%v", err)
+  }
+  checkMemoryTFIDFScores(t, restored)
+
+  err = restored.ReadSnapshot(bytes.NewBufferString("not a snapshot"))
+  if err == nil {
+    t.Errorf("Should have thrown an error while reading an invalid snapshot")
+  }
+  checkMemoryTFIDFScores(t, restored)
+
+  dir, err := ioutil.TempDir("", "updike-tfidf")
+  if err != nil {
+    t.Fatalf("Could not create a directory for the snapshot: err=%v", err)
+  }
+  defer os.RemoveAll(dir)
+
+  filename := filepath.Join(dir, "tfidf.snapshot")
+  err = setupMemoryTFIDF(t).SaveSnapshot(filename)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to save a snapshot: err=%v", err)
+  }
+
+  loaded, err := LoadMemoryTFIDF(filename)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to load a snapshot: err=%v", err)
+  }
+  checkMemoryTFIDFScores(t, loaded)
+
+  files, _ := ioutil.ReadDir(dir)
+  if len(files) != 1 {
+    t.Errorf("Saving a snapshot should only have left the snapshot behind, instead found %d files", len(files))
+  }
+}
+
+func TestMemoryTFIDFStoreDocument(t *testing.T) {
+  tfidf := NewMemoryTFIDF()
+  documents := map[int][]string{
+    1: {"the", "cat", "and", "the", "hat", "and", "the", "bat"},
+    2: {"a", "cat"},
+  }
+  for documentId, words := range documents {
+    err := tfidf.StoreDocument(documentId, words)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to store a document: err=%v", err)
+    }
+  }
+
+  fixtures := []struct {
+    Word string
+    DocumentId int
+    ExpectedTF float64
+    ExpectedIDF float64
+  }{
+    {"the", 1, 1.0, 0.0},
+    {"and", 1, 0.833333333, 0.0},
+    {"cat", 1, 0.666666667, -0.176091259},
+    {"cat", 2, 1.0, -0.176091259},
+    {"hat", 2, 0.5, 0.0},
+  }
+
+  for _, f := range fixtures {
+    tfScore, err := tfidf.TermFrequency(f.Word, f.DocumentId)
+    if err != nil || math.Abs(tfScore - f.ExpectedTF) > floatEqualThresh {
+      t.Errorf("Received unexpected TF value: word=%v, result=%v, expected=%v, err=%v",
+        f.Word, tfScore, f.ExpectedTF, err)
+    }
+
+    idfScore, err := tfidf.InverseDocumentFrequency(f.Word)
+    if err != nil || math.Abs(idfScore - f.ExpectedIDF) > floatEqualThresh {
+      t.Errorf("Received unexpected IDF value: word=%v, result=%v, expected=%v, err=%v",
+        f.Word, idfScore, f.ExpectedIDF, err)
+    }
+  }
+
+  // Storing a document again replaces its words.
+  err := tfidf.StoreDocument(1, []string{"dog"})
+  if err != nil {
+    t.Errorf("Obtained an error while trying to store a document: err=%v", err)
+  }
+  if tfidf.index.documentFrequency["cat"] != 1 || tfidf.index.documentFrequency["the"] != 0 {
+    t.Errorf("Storing a document again should have replaced its words, instead obtained %v",
+      tfidf.index.documentFrequency)
+  }
+  tfScore, _ := tfidf.TermFrequency("the", 1)
+  if math.Abs(tfScore - 0.5) > floatEqualThresh {
+    t.Errorf("Received unexpected TF value for a replaced word: result=%v, expected=0.5", tfScore)
+  }
+}
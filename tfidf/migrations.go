@@ -0,0 +1,84 @@
+package tfidf
+
+import (
+  "github.com/wangjohn/updike/migration"
+)
+
+const (
+  tfidfMigrationComponent = "philarios_tfidf"
+)
+
+/*
+tfidfMigrations are the ordered changes to the schema used by PersistentTFIDF.
+New changes to the schema must be appended as new migrations rather than
+edited in place.
+*/
+var tfidfMigrations = []migration.Migration{
+  {
+    Version: 1,
+    Description: "create word_document_pairs and document_frequency",
+    Up: `
+CREATE TABLE IF NOT EXISTS word_document_pairs (
+  id bigserial PRIMARY KEY,
+  word text,
+  freq integer,
+  doc_max_word_freq integer,
+  document bigserial
+);
+
+CREATE TABLE IF NOT EXISTS document_frequency (
+  id bigserial PRIMARY KEY,
+  word text,
+  unique_documents integer
+);
+`,
+  },
+  {
+    Version: 2,
+    Description: "remove duplicate words, recount unique documents and make words unique",
+    Up: `
+DELETE FROM word_document_pairs a
+  USING word_document_pairs b
+  WHERE a.word = b.word AND a.document = b.document AND a.id < b.id;
+
+DELETE FROM document_frequency;
+INSERT INTO document_frequency (word, unique_documents)
+  SELECT word, COUNT(*) FROM word_document_pairs GROUP BY word;
+
+CREATE UNIQUE INDEX word_document_pairs_word_document_idx ON word_document_pairs (word, document);
+CREATE UNIQUE INDEX document_frequency_word_idx ON document_frequency (word);
+`,
+  },
+  {
+    Version: 3,
+    Description: "record the documents in the corpus",
+    Up: `
+CREATE TABLE documents (
+  document bigint PRIMARY KEY
+);
+
+INSERT INTO documents (document)
+  SELECT DISTINCT document FROM word_document_pairs;
+`,
+  },
+  {
+    Version: 4,
+    Description: "record the number of words in each document",
+    Up: `
+ALTER TABLE documents ADD COLUMN length integer NOT NULL DEFAULT 0;
+
+UPDATE documents SET length = lengths.length
+  FROM (SELECT document, SUM(freq) AS length FROM word_document_pairs GROUP BY document) lengths
+  WHERE documents.document = lengths.document;
+`,
+  },
+}
+
+/*
+Migrate brings the schema of the database up to date by applying any TFIDF
+migrations that have not yet been applied. It should be called once, before
+the TFIDF is used.
+*/
+func (p PersistentTFIDF) Migrate() (error) {
+  return migration.MigrateContext(p.Context(), p.SQLDatabase, tfidfMigrationComponent, tfidfMigrations)
+}
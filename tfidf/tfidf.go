@@ -3,53 +3,83 @@ package tfidf
 import (
   "github.com/reiver/go-porterstemmer"
 
-  _ "github.com/lib/pq"
+  "github.com/lib/pq"
+  "github.com/wangjohn/updike/pgcopy"
+  "context"
   "database/sql"
-  "math"
   "fmt"
 )
 
+/*
+TFIDF stores the frequencies of words in documents and scores words by their
+TF-IDF. StoreDocument indexes every word of a document at once, replacing
+anything stored for the document before, while Store records a single word.
+WithContext returns a copy of the TFIDF whose methods are bound to the
+given context, so that they return the context's error instead of completing
+once it is cancelled or its deadline passes.
+*/
 type TFIDF interface {
+  WithContext(ctx context.Context) (TFIDF)
   Store(word string, occurrences, docMaxWordOccurrences, documentId int) (error)
+  StoreDocument(documentId int, words []string) (error)
   TermFrequency(word string, documentId int) (float64, error)
   InverseDocumentFrequency(word string) (float64, error)
   Score(word string, documentId int) (float64, error)
+  RemoveDocument(documentId int) (error)
   NormalizeWord(word string) (string, error)
 }
 
+/*
+PersistentTFIDF is an implementation of TFIDF which stores the frequencies of
+words in a database. It should be created with NewPersistentTFIDF so that the
+number of documents in the corpus is cached, and copies of it share the same
+cache. Words are weighed by the Weighting scheme, which is the default scheme
+unless it is set.
+*/
 type PersistentTFIDF struct {
   SQLDatabase *sql.DB
+  Weighting WeightingScheme
+  ctx context.Context
+  corpusSize *corpusSize
 }
 
-var persistentSqlSchema = `
-CREATE TABLE IF NOT EXISTS word_document_pairs (
-  id bigserial PRIMARY KEY,
-  word text,
-  freq integer,
-  doc_max_word_freq integer,
-  document bigserial
-);
+/*
+NewPersistentTFIDF returns a PersistentTFIDF which stores words in the given
+database.
+*/
+func NewPersistentTFIDF(db *sql.DB) (PersistentTFIDF) {
+  return PersistentTFIDF{SQLDatabase: db, corpusSize: &corpusSize{}}
+}
 
-CREATE TABLE IF NOT EXISTS document_frequency (
-  id bigserial PRIMARY KEY,
-  word text,
-  unique_documents integer
-);
-`
+/*
+WithContext returns a copy of the TFIDF which uses ctx for all of its database
+calls.
+*/
+func (p PersistentTFIDF) WithContext(ctx context.Context) (TFIDF) {
+  p.ctx = ctx
+  return p
+}
 
-func (p PersistentTFIDF) EnsureSchema() (error) {
-  _, err := p.SQLDatabase.Exec(persistentSqlSchema)
-  return err
+/*
+Context returns the context that the TFIDF's database calls are made with,
+which is context.Background() unless one was given to WithContext.
+*/
+func (p PersistentTFIDF) Context() (context.Context) {
+  if p.ctx == nil {
+    return context.Background()
+  }
+  return p.ctx
 }
 
 func (p PersistentTFIDF) TermFrequency(word string, documentId int) (float64, error) {
+  ctx := p.Context()
   word, err := p.NormalizeWord(word)
   if err != nil {
     return 0.0, err
   }
 
   var freq, docMaxWordFreq int
-  err = p.SQLDatabase.QueryRow(
+  err = p.SQLDatabase.QueryRowContext(ctx,
     `SELECT freq, doc_max_word_freq FROM word_document_pairs
      WHERE word=$1
      AND document=$2`, word, documentId).Scan(&freq, &docMaxWordFreq)
@@ -58,7 +88,7 @@ func (p PersistentTFIDF) TermFrequency(word string, documentId int) (float64, er
     // We don't have that word, document pair, so just set freq to zero.
     freq = 0
 
-    findMaxFreqErr := p.SQLDatabase.QueryRow(
+    findMaxFreqErr := p.SQLDatabase.QueryRowContext(ctx,
       `SELECT doc_max_word_freq FROM word_document_pairs
        WHERE document=$1
        LIMIT 1`, documentId).Scan(&docMaxWordFreq)
@@ -72,23 +102,34 @@ func (p PersistentTFIDF) TermFrequency(word string, documentId int) (float64, er
     return 0.0, err
   }
 
-  return tfFunc(freq, docMaxWordFreq), nil
-}
+  counts := TermCounts{Freq: freq, DocMaxWordFreq: docMaxWordFreq}
+  if p.Weighting.DocumentLengths {
+    err = p.SQLDatabase.QueryRowContext(ctx,
+      `SELECT length FROM documents
+       WHERE document=$1`, documentId).Scan(&counts.DocLength)
+    if err != nil && err != sql.ErrNoRows {
+      return 0.0, err
+    }
 
-func tfFunc(frequency, docMaxWordFrequency int) float64 {
-  return 0.5 + (0.5 * float64(frequency)) / float64(docMaxWordFrequency)
-}
+    size, err := p.size()
+    if err != nil {
+      return 0.0, err
+    }
+    counts.AvgDocLength = size.averageLength()
+  }
 
-var totalDocs = -1
+  return p.Weighting.termFrequency(counts), nil
+}
 
 func (p PersistentTFIDF) InverseDocumentFrequency(word string) (float64, error) {
+  ctx := p.Context()
   word, err := p.NormalizeWord(word)
   if err != nil {
     return 0.0, err
   }
 
   var uniqDocs int
-  err = p.SQLDatabase.QueryRow(
+  err = p.SQLDatabase.QueryRowContext(ctx,
     `SELECT unique_documents FROM document_frequency
     WHERE word=$1`, word).Scan(&uniqDocs)
 
@@ -98,19 +139,12 @@ func (p PersistentTFIDF) InverseDocumentFrequency(word string) (float64, error)
     return 0.0, err
   }
 
-  if totalDocs == -1 {
-    err = p.SQLDatabase.QueryRow(
-      `SELECT COUNT(DISTINCT document) FROM word_document_pairs`).Scan(&totalDocs)
-    if err != nil {
-      return 0.0, err
-    }
+  totalDocs, err := p.TotalDocuments()
+  if err != nil {
+    return 0.0, err
   }
 
-  return idfFunc(uniqDocs, totalDocs), nil
-}
-
-func idfFunc(docs, totDocs int) (float64) {
-  return math.Log10(float64(totDocs) / (1.0 + float64(docs)))
+  return p.Weighting.inverseDocumentFrequency(uniqDocs, totalDocs), nil
 }
 
 func (p PersistentTFIDF) Score(word string, documentId int) (float64, error) {
@@ -132,81 +166,327 @@ func (p PersistentTFIDF) Score(word string, documentId int) (float64, error) {
   return tf * idf, nil
 }
 
+/*
+Store records the occurrences of a word in a document, replacing any
+occurrences stored for the same word and document before. The word and its
+number of unique documents are upserted in a single transaction, so that
+concurrent calls neither duplicate rows nor miscount documents.
+*/
 func (p PersistentTFIDF) Store(word string, occurrences, docMaxWordOccurrences, documentId int) (error) {
+  ctx := p.Context()
   word, err := p.NormalizeWord(word)
   if err != nil {
     return err
   }
 
-  var isNewDocument bool
-  var id int
-  wordQueryErr := p.SQLDatabase.QueryRow(
-   `SELECT id FROM word_document_pairs
-    WHERE word=$1
-    AND document=$2`, word, documentId).Scan(&id)
-
-  if wordQueryErr == sql.ErrNoRows {
-    isNewDocument = true
-    wordInsErr := p.SQLDatabase.QueryRow(
-     `INSERT INTO word_document_pairs(
-        word, freq, doc_max_word_freq, document)
-      VALUES ($1, $2, $3, $4)
-      RETURNING id`,
-      word,
-      occurrences,
-      docMaxWordOccurrences,
-      documentId).Scan(&id)
-    if wordInsErr != nil {
-      return wordInsErr
-    }
-  } else if wordQueryErr == nil {
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+
+  // A pair which already exists conflicts rather than being inserted, so that
+  // no row is returned and only its frequencies are updated.
+  var id, previousOccurrences int
+  isNewDocument := true
+  err = txn.QueryRowContext(ctx,
+   `INSERT INTO word_document_pairs(
+      word, freq, doc_max_word_freq, document)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (word, document) DO NOTHING
+    RETURNING id`,
+    word,
+    occurrences,
+    docMaxWordOccurrences,
+    documentId).Scan(&id)
+
+  if err == sql.ErrNoRows {
     isNewDocument = false
-    _, wordUpdErr := p.SQLDatabase.Exec(
+    err = txn.QueryRowContext(ctx,
+     `SELECT freq FROM word_document_pairs
+      WHERE word=$1
+      AND document=$2
+      FOR UPDATE`, word, documentId).Scan(&previousOccurrences)
+    if err != nil {
+      txn.Rollback()
+      return err
+    }
+
+    _, err = txn.ExecContext(ctx,
      `UPDATE word_document_pairs
-      SET freq=$1
-      AND doc_max_word_freq=$2
+      SET freq=$1, doc_max_word_freq=$2
       WHERE word=$3
       AND document=$4`,
       occurrences,
       docMaxWordOccurrences,
       word,
       documentId)
-    if wordUpdErr != nil {
-      return wordUpdErr
+  }
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  addedDocuments := 0
+  addedWords := occurrences - previousOccurrences
+  if isNewDocument {
+    _, err = txn.ExecContext(ctx,
+     `INSERT INTO document_frequency(word, unique_documents)
+      VALUES ($1, 1)
+      ON CONFLICT (word) DO UPDATE
+      SET unique_documents = document_frequency.unique_documents + 1`, word)
+    if err != nil {
+      txn.Rollback()
+      return err
+    }
+
+    addedDocuments, err = addDocument(ctx, txn, documentId, 0)
+    if err != nil {
+      txn.Rollback()
+      return err
+    }
+  }
+
+  _, err = txn.ExecContext(ctx,
+    `UPDATE documents
+     SET length = length + $1
+     WHERE document=$2`, addedWords, documentId)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  err = txn.Commit()
+  if err != nil {
+    return err
+  }
+
+  p.corpusSize.add(addedDocuments, addedWords)
+  return nil
+}
+
+/*
+StoreDocument normalizes and counts the words of a document, and stores all of
+them in a single transaction. Anything stored for the document before is
+replaced.
+*/
+func (p PersistentTFIDF) StoreDocument(documentId int, words []string) (error) {
+  ctx := p.Context()
+  counts, docMaxWordFreq, err := countWords(p, words)
+  if err != nil {
+    return err
+  }
+
+  length := 0
+  for _, freq := range counts {
+    length += freq
+  }
+
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+
+  removedDocuments, removedWords, err := removeDocument(ctx, txn, documentId)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  rows := make([][]interface{}, 0, len(counts))
+  for word, freq := range counts {
+    rows = append(rows, []interface{}{word, freq, docMaxWordFreq, documentId})
+  }
+  err = pgcopy.CopyRows(ctx, txn, pq.CopyIn("word_document_pairs",
+    "word", "freq", "doc_max_word_freq", "document"), rows)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  // Rows of document_frequency are upserted in order of their words, so that
+  // concurrent transactions lock them in the same order.
+  _, err = txn.ExecContext(ctx,
+    `INSERT INTO document_frequency(word, unique_documents)
+     SELECT word, 1 FROM word_document_pairs
+     WHERE document=$1
+     ORDER BY word
+     ON CONFLICT (word) DO UPDATE
+     SET unique_documents = document_frequency.unique_documents + 1`, documentId)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  addedDocuments := 0
+  if len(counts) > 0 {
+    addedDocuments, err = addDocument(ctx, txn, documentId, length)
+    if err != nil {
+      txn.Rollback()
+      return err
     }
-  } else {
-    return wordQueryErr
   }
 
-  // Update the number of unique documents
-  var docFreqId int
-  docFreqQueryErr := p.SQLDatabase.QueryRow(
-    `SELECT id FROM document_frequency
-     WHERE word=$1`, word).Scan(&docFreqId)
+  err = txn.Commit()
+  if err != nil {
+    return err
+  }
+
+  p.corpusSize.add(addedDocuments - removedDocuments, length - removedWords)
+  return nil
+}
 
-  if docFreqQueryErr == sql.ErrNoRows {
-    docFreqInsErr := p.SQLDatabase.QueryRow(
-     `INSERT INTO document_frequency(
-        word, unique_documents)
-      VALUES ($1, $2)
-      RETURNING id`, word, 0).Scan(&docFreqId)
+/*
+countWords returns the number of occurrences of each of the words after they
+are normalized by the TFIDF, along with the largest of those numbers. Words
+which normalize to an empty string are ignored.
+*/
+func countWords(t TFIDF, words []string) (map[string]int, int, error) {
+  counts := make(map[string]int)
+  maxCount := 0
+  for _, word := range words {
+    word, err := t.NormalizeWord(word)
+    if err != nil {
+      return nil, 0, err
+    }
+    if word == "" {
+      continue
+    }
 
-    if docFreqInsErr != nil {
-      return docFreqInsErr
+    counts[word]++
+    if counts[word] > maxCount {
+      maxCount = counts[word]
     }
-  } else if docFreqQueryErr != nil {
-    return docFreqQueryErr
   }
 
-  if isNewDocument {
-    _, err = p.SQLDatabase.Exec(
-      `UPDATE document_frequency
-       SET unique_documents = unique_documents + 1
-       WHERE id=$1`, docFreqId)
+  return counts, maxCount, nil
+}
+
+/*
+RemoveDocument removes every word stored for a document, and decrements the
+number of unique documents of each of those words accordingly. Removing a
+document which doesn't exist does nothing.
+*/
+func (p PersistentTFIDF) RemoveDocument(documentId int) (error) {
+  ctx := p.Context()
+  txn, err := p.SQLDatabase.BeginTx(ctx, nil)
+  if err != nil {
     return err
-  } else {
-    return nil
   }
+
+  removedDocuments, removedWords, err := removeDocument(ctx, txn, documentId)
+  if err != nil {
+    txn.Rollback()
+    return err
+  }
+
+  err = txn.Commit()
+  if err != nil {
+    return err
+  }
+
+  p.corpusSize.add(-removedDocuments, -removedWords)
+  return nil
+}
+
+/*
+addDocument records that the document, of the given length, is in the corpus.
+It returns the number of documents added to the corpus, which is zero if it
+already was, in which case its length is left unchanged.
+*/
+func addDocument(ctx context.Context, txn *sql.Tx, documentId, length int) (int, error) {
+  result, err := txn.ExecContext(ctx,
+    `INSERT INTO documents(document, length)
+     VALUES ($1, $2)
+     ON CONFLICT (document) DO NOTHING`, documentId, length)
+  if err != nil {
+    return 0, err
+  }
+
+  added, err := result.RowsAffected()
+  return int(added), err
+}
+
+/*
+removeDocument removes the words of the document and the document itself from
+the corpus, and returns the number of documents and of words removed from the
+corpus.
+*/
+func removeDocument(ctx context.Context, txn *sql.Tx, documentId int) (int, int, error) {
+  words, err := documentWords(ctx, txn, documentId)
+  if err != nil {
+    return 0, 0, err
+  }
+
+  // Rows of document_frequency are locked in order of their words, as they are
+  // when a document is stored, so that concurrent transactions don't deadlock.
+  _, err = txn.ExecContext(ctx,
+    `SELECT word FROM document_frequency
+     WHERE word = ANY($1)
+     ORDER BY word
+     FOR UPDATE`, pq.Array(words))
+  if err != nil {
+    return 0, 0, err
+  }
+
+  _, err = txn.ExecContext(ctx,
+    `UPDATE document_frequency
+     SET unique_documents = unique_documents - 1
+     WHERE word = ANY($1)`, pq.Array(words))
+  if err != nil {
+    return 0, 0, err
+  }
+
+  _, err = txn.ExecContext(ctx,
+    `DELETE FROM word_document_pairs
+     WHERE document=$1`, documentId)
+  if err != nil {
+    return 0, 0, err
+  }
+
+  _, err = txn.ExecContext(ctx,
+    `DELETE FROM document_frequency
+     WHERE word = ANY($1) AND unique_documents <= 0`, pq.Array(words))
+  if err != nil {
+    return 0, 0, err
+  }
+
+  var length int
+  err = txn.QueryRowContext(ctx,
+    `DELETE FROM documents
+     WHERE document=$1
+     RETURNING length`, documentId).Scan(&length)
+  if err == sql.ErrNoRows {
+    return 0, 0, nil
+  } else if err != nil {
+    return 0, 0, err
+  }
+
+  return 1, length, nil
+}
+
+/*
+documentWords returns the distinct words of the document, in order.
+*/
+func documentWords(ctx context.Context, txn *sql.Tx, documentId int) ([]string, error) {
+  rows, err := txn.QueryContext(ctx,
+    `SELECT DISTINCT word FROM word_document_pairs
+     WHERE document=$1
+     ORDER BY word`, documentId)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  words := make([]string, 0)
+  for rows.Next() {
+    var word string
+    if err = rows.Scan(&word); err != nil {
+      return nil, err
+    }
+    words = append(words, word)
+  }
+
+  return words, rows.Err()
 }
 
 func (p PersistentTFIDF) NormalizeWord(word string) (string, error) {
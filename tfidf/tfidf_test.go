@@ -2,6 +2,7 @@ package tfidf
 
 import (
   "database/sql"
+  "sync"
   "testing"
   "math"
 )
@@ -19,13 +20,13 @@ func setupDatabase() (*PersistentTFIDF, *sql.DB, error) {
     return nil, nil, err
   }
 
-  tfidf := PersistentTFIDF{db}
+  tfidf := NewPersistentTFIDF(db)
   err = clearDatabase(db)
   if err != nil {
     return nil, nil, err
   }
 
-  err = tfidf.EnsureSchema()
+  err = tfidf.Migrate()
   if err != nil {
     return nil, nil, err
   }
@@ -37,6 +38,8 @@ func clearDatabase(db *sql.DB) (error) {
   _, err := db.Exec(`
     DROP TABLE IF EXISTS word_document_pairs;
     DROP TABLE IF EXISTS document_frequency;
+    DROP TABLE IF EXISTS documents;
+    DROP TABLE IF EXISTS schema_version;
   `)
   return err
 }
@@ -105,6 +108,11 @@ func TestInverseDocumentFrequency(t *testing.T) {
     ('hello', 2),
     ('tango', 1),
     ('blend', 1);
+
+    INSERT INTO documents
+    (document) VALUES
+    (1),
+    (2);
   `)
 
   if err != nil {
@@ -156,6 +164,11 @@ func TestTFIDF(t *testing.T) {
     ('hello', 2),
     ('tango', 1),
     ('blend', 1);
+
+    INSERT INTO documents
+    (document) VALUES
+    (1),
+    (2);
   `)
 
   if err != nil {
@@ -264,3 +277,295 @@ func TestStoreWord(t *testing.T) {
     }
   }
 }
+
+func TestStoreWordConcurrently(t *testing.T) {
+  tfidf, db, err := setupDatabase()
+  defer clearDatabase(db)
+  if err != nil {
+    t.Errorf("Should not have thrown an error while setting up database: err=%v", err)
+  }
+
+  // Every document stores every word several times at once, which would
+  // create duplicate rows without upserts.
+  words := []string{"hello", "tango", "blend"}
+  documents := 5
+  repeats := 3
+
+  var wg sync.WaitGroup
+  errs := make(chan error, documents * repeats * len(words))
+  for documentId := 1; documentId <= documents; documentId++ {
+    for i := 0; i < repeats; i++ {
+      for _, word := range words {
+        wg.Add(1)
+        go func(word string, documentId int) {
+          defer wg.Done()
+          errs <- tfidf.Store(word, 2, 4, documentId)
+        }(word, documentId)
+      }
+    }
+  }
+  wg.Wait()
+  close(errs)
+
+  for err := range errs {
+    if err != nil {
+      t.Errorf("Obtained an error while trying to store words concurrently: err=%v", err)
+    }
+  }
+
+  var pairs int
+  err = db.QueryRow(`SELECT COUNT(*) FROM word_document_pairs`).Scan(&pairs)
+  if err != nil || pairs != documents * len(words) {
+    t.Errorf("Should have stored %d word document pairs, instead stored %d (err=%v)",
+      documents * len(words), pairs, err)
+  }
+
+  for _, word := range words {
+    var rows, uniqueDocuments int
+    err = db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(unique_documents), 0) FROM document_frequency
+      WHERE word=$1`, word).Scan(&rows, &uniqueDocuments)
+    if err != nil || rows != 1 || uniqueDocuments != documents {
+      t.Errorf("Should have counted %d unique documents for %v in one row, instead counted %d in %d rows (err=%v)",
+        documents, word, uniqueDocuments, rows, err)
+    }
+  }
+
+  // Storing a word again updates both of its frequencies.
+  err = tfidf.Store("hello", 8, 8, 1)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to store words: err=%v", err)
+  }
+  tfScore, err := tfidf.TermFrequency("hello", 1)
+  if err != nil || math.Abs(tfScore - 1.0) > floatEqualThresh {
+    t.Errorf("Received unexpected TF value after storing a word again: result=%v, expected=1.0 (err=%v)",
+      tfScore, err)
+  }
+}
+
+func TestRemoveDocument(t *testing.T) {
+  tfidf, db, err := setupDatabase()
+  defer clearDatabase(db)
+  if err != nil {
+    t.Errorf("Should not have thrown an error while setting up database: err=%v", err)
+  }
+
+  storageFixtures := []struct {
+    Word string
+    Occurrences int
+    DocMaxWordOccurrences int
+    DocumentId int
+  }{
+    {"hello", 15, 43, 1},
+    {"tango", 32, 33, 2},
+    {"hello", 1, 50, 2},
+    {"blend", 3, 100, 1},
+  }
+
+  for _, f := range storageFixtures {
+    err = tfidf.Store(f.Word, f.Occurrences, f.DocMaxWordOccurrences, f.DocumentId)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to store words: err=%v", err)
+    }
+  }
+
+  err = tfidf.RemoveDocument(1)
+  if err != nil {
+    t.Errorf("Obtained an error while trying to remove a document: err=%v", err)
+  }
+
+  fixtures := []struct {
+    Word string
+    ExpectedUniqueDocuments int
+  }{
+    {"hello", 1},
+    {"tango", 1},
+    {"blend", 0},
+  }
+
+  for _, f := range fixtures {
+    var uniqueDocuments int
+    err = db.QueryRow(`SELECT COALESCE(SUM(unique_documents), 0) FROM document_frequency
+      WHERE word=$1`, f.Word).Scan(&uniqueDocuments)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to count unique documents: err=%v", err)
+    }
+    if uniqueDocuments != f.ExpectedUniqueDocuments {
+      t.Errorf("Received unexpected unique documents: word=%v, result=%v, expected=%v",
+        f.Word, uniqueDocuments, f.ExpectedUniqueDocuments)
+    }
+  }
+
+  _, err = tfidf.TermFrequency("blend", 1)
+  if err == nil {
+    t.Errorf("Should have thrown an error for the term frequency of a removed document")
+  }
+}
+
+func TestStoreDocument(t *testing.T) {
+  tfidf, db, err := setupDatabase()
+  defer clearDatabase(db)
+  if err != nil {
+    t.Errorf("Should not have thrown an error while setting up database: err=%v", err)
+  }
+
+  documents := map[int][]string{
+    1: {"the", "cat", "and", "the", "hat", "and", "the", "bat"},
+    2: {"a", "cat"},
+  }
+  for documentId, words := range documents {
+    err = tfidf.StoreDocument(documentId, words)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to store a document: err=%v", err)
+    }
+  }
+
+  // Storing a document again replaces its words.
+  err = tfidf.StoreDocument(2, []string{"a", "cat", "cat"})
+  if err != nil {
+    t.Errorf("Obtained an error while trying to store a document: err=%v", err)
+  }
+
+  fixtures := []struct {
+    Word string
+    DocumentId int
+    ExpectedTF float64
+    ExpectedUniqueDocuments int
+  }{
+    {"the", 1, 1.0, 1},
+    {"and", 1, 0.833333333, 1},
+    {"cat", 1, 0.666666667, 2},
+    {"cat", 2, 1.0, 2},
+    {"a", 2, 0.75, 1},
+    {"hat", 2, 0.5, 1},
+  }
+
+  for _, f := range fixtures {
+    tfScore, err := tfidf.TermFrequency(f.Word, f.DocumentId)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to get Term Frequency: err=%v", err)
+    }
+    if math.Abs(tfScore - f.ExpectedTF) > floatEqualThresh {
+      t.Errorf("Received unexpected TF value: word=%v, result=%v, expected=%v",
+        f.Word, tfScore, f.ExpectedTF)
+    }
+
+    var uniqueDocuments int
+    err = db.QueryRow(`SELECT COALESCE(SUM(unique_documents), 0) FROM document_frequency
+      WHERE word=$1`, f.Word).Scan(&uniqueDocuments)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to count unique documents: err=%v", err)
+    }
+    if uniqueDocuments != f.ExpectedUniqueDocuments {
+      t.Errorf("Received unexpected unique documents: word=%v, result=%v, expected=%v",
+        f.Word, uniqueDocuments, f.ExpectedUniqueDocuments)
+    }
+  }
+}
+
+func TestCorpusSize(t *testing.T) {
+  tfidf, db, err := setupDatabase()
+  defer clearDatabase(db)
+  if err != nil {
+    t.Errorf("Should not have thrown an error while setting up database: err=%v", err)
+  }
+  other := NewPersistentTFIDF(db)
+
+  steps := []struct {
+    Name string
+    Update func() (error)
+    ExpectedDocuments int
+  }{
+    {"empty corpus", func() (error) { return nil }, 0},
+    {"storing a word", func() (error) { return tfidf.Store("hello", 1, 2, 1) }, 1},
+    {"storing another word in the same document", func() (error) { return tfidf.Store("tango", 2, 2, 1) }, 1},
+    {"storing a document", func() (error) { return tfidf.StoreDocument(2, []string{"blend"}) }, 2},
+    {"storing the document again", func() (error) { return tfidf.StoreDocument(2, []string{"tango"}) }, 2},
+    {"storing a document through another TFIDF", func() (error) { return other.StoreDocument(3, []string{"hello"}) }, 2},
+    {"refreshing", func() (error) { return tfidf.RefreshCorpusSize() }, 3},
+    {"removing a document", func() (error) { return tfidf.RemoveDocument(1) }, 2},
+    {"removing a nonexistent document", func() (error) { return tfidf.RemoveDocument(1) }, 2},
+    {"storing an empty document", func() (error) { return tfidf.StoreDocument(2, nil) }, 1},
+  }
+
+  for _, step := range steps {
+    err = step.Update()
+    if err != nil {
+      t.Errorf("Obtained an error while %s: err=%v", step.Name, err)
+    }
+
+    documents, err := tfidf.TotalDocuments()
+    if err != nil {
+      t.Errorf("Obtained an error while counting documents after %s: err=%v", step.Name, err)
+    }
+    if documents != step.ExpectedDocuments {
+      t.Errorf("Received unexpected number of documents after %s: result=%v, expected=%v",
+        step.Name, documents, step.ExpectedDocuments)
+    }
+  }
+
+  // The documents stored through the other TFIDF are counted by a new one.
+  documents, err := NewPersistentTFIDF(db).TotalDocuments()
+  if err != nil || documents != 1 {
+    t.Errorf("Received unexpected number of documents for a new TFIDF: result=%v, expected=1 (err=%v)",
+      documents, err)
+  }
+}
+
+func TestBM25Scores(t *testing.T) {
+  tfidf, db, err := setupDatabase()
+  defer clearDatabase(db)
+  if err != nil {
+    t.Errorf("Should not have thrown an error while setting up database: err=%v", err)
+  }
+  tfidf.Weighting = BM25(DefaultBM25K1, DefaultBM25B)
+
+  // The length of a document stored word by word is the sum of the
+  // occurrences of its words, so both documents are the same as in
+  // TestBM25MemoryTFIDF.
+  err = tfidf.StoreDocument(1, []string{"a", "a", "b"})
+  if err != nil {
+    t.Errorf("Obtained an error while trying to store a document: err=%v", err)
+  }
+  storageFixtures := []struct {
+    Word string
+    Occurrences int
+  }{
+    {"a", 1},
+    {"c", 2},
+    {"c", 5},
+  }
+  for _, f := range storageFixtures {
+    err = tfidf.Store(f.Word, f.Occurrences, 5, 2)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to store words: err=%v", err)
+    }
+  }
+
+  fixtures := []struct {
+    Word string
+    DocumentId int
+    ExpectedScore float64
+  }{
+    {"a", 1, 0.276625810},
+    {"a", 2, 0.160442970},
+    {"c", 2, 1.173018306},
+    {"b", 2, 0.0},
+  }
+
+  for _, f := range fixtures {
+    score, err := tfidf.Score(f.Word, f.DocumentId)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to get BM25 score: err=%v", err)
+    }
+    if math.Abs(score - f.ExpectedScore) > floatEqualThresh {
+      t.Errorf("Received unexpected BM25 value: word=%v, document=%v, result=%v, expected=%v",
+        f.Word, f.DocumentId, score, f.ExpectedScore)
+    }
+  }
+
+  var length int
+  err = db.QueryRow(`SELECT length FROM documents WHERE document=2`).Scan(&length)
+  if err != nil || length != 6 {
+    t.Errorf("Should have recorded a length of 6 for document 2, instead recorded %d (err=%v)", length, err)
+  }
+}
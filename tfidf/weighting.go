@@ -0,0 +1,143 @@
+package tfidf
+
+import (
+  "math"
+)
+
+const (
+  DefaultBM25K1 = 1.2
+  DefaultBM25B = 0.75
+)
+
+/*
+TermCounts describes the occurrences of a word in a document. DocLength is the
+number of words in the document, and AvgDocLength the average number of words
+in the documents of the corpus. They are only filled in for weighting schemes
+which set DocumentLengths, and are zero otherwise.
+*/
+type TermCounts struct {
+  Freq int
+  DocMaxWordFreq int
+  DocLength int
+  AvgDocLength float64
+}
+
+/*
+WeightingScheme decides how a TFIDF weighs words. TF weighs the occurrences of
+a word in a document, and IDF weighs the number of documents containing a word
+out of the total number of documents. A TFIDF's score for a word is the
+product of the two. DocumentLengths must be set if TF uses the lengths of
+documents, since looking them up costs extra queries. A nil TF or IDF falls
+back to AugmentedTF or SmoothedIDF, so the zero WeightingScheme is the
+default scheme.
+*/
+type WeightingScheme struct {
+  TF func(counts TermCounts) (float64)
+  IDF func(documents, totalDocuments int) (float64)
+  DocumentLengths bool
+}
+
+/*
+DefaultWeightingScheme returns the scheme used by a TFIDF unless it is given
+another one, which weighs words by their AugmentedTF and SmoothedIDF.
+*/
+func DefaultWeightingScheme() (WeightingScheme) {
+  return WeightingScheme{TF: AugmentedTF, IDF: SmoothedIDF}
+}
+
+/*
+BM25 returns the Okapi BM25 scheme with the given parameters. k1 limits how
+much repeated occurrences of a word add to its weight, and b between 0 and 1
+is how much the weight of a word in a long document is reduced.
+DefaultBM25K1 and DefaultBM25B are the usual choices.
+*/
+func BM25(k1, b float64) (WeightingScheme) {
+  return WeightingScheme{
+    TF: func(counts TermCounts) (float64) {
+      freq := float64(counts.Freq)
+      norm := 1.0
+      if counts.AvgDocLength > 0 {
+        norm = 1.0 - b + b * float64(counts.DocLength) / counts.AvgDocLength
+      }
+      return freq * (k1 + 1.0) / (freq + k1 * norm)
+    },
+    IDF: BM25IDF,
+    DocumentLengths: true,
+  }
+}
+
+func (w WeightingScheme) termFrequency(counts TermCounts) (float64) {
+  if w.TF == nil {
+    return AugmentedTF(counts)
+  }
+  return w.TF(counts)
+}
+
+func (w WeightingScheme) inverseDocumentFrequency(documents, totalDocuments int) (float64) {
+  if w.IDF == nil {
+    return SmoothedIDF(documents, totalDocuments)
+  }
+  return w.IDF(documents, totalDocuments)
+}
+
+/*
+RawTF weighs a word by its number of occurrences in the document.
+*/
+func RawTF(counts TermCounts) (float64) {
+  return float64(counts.Freq)
+}
+
+/*
+LogTF weighs a word by the logarithm of its number of occurrences, so that
+repeated occurrences add less and less to its weight.
+*/
+func LogTF(counts TermCounts) (float64) {
+  if counts.Freq <= 0 {
+    return 0.0
+  }
+  return 1.0 + math.Log10(float64(counts.Freq))
+}
+
+/*
+AugmentedTF weighs a word by its number of occurrences relative to the most
+frequent word of the document, between 0.5 and 1, which prevents a bias
+towards long documents.
+*/
+func AugmentedTF(counts TermCounts) (float64) {
+  return 0.5 + (0.5 * float64(counts.Freq)) / float64(counts.DocMaxWordFreq)
+}
+
+/*
+BooleanTF weighs a word by whether it occurs in the document at all.
+*/
+func BooleanTF(counts TermCounts) (float64) {
+  if counts.Freq > 0 {
+    return 1.0
+  }
+  return 0.0
+}
+
+/*
+SmoothedIDF is log10(N / (1 + df)) for a word in df of the N documents, where
+the 1 avoids dividing by zero for words which aren't in any document.
+*/
+func SmoothedIDF(documents, totalDocuments int) (float64) {
+  return math.Log10(float64(totalDocuments) / (1.0 + float64(documents)))
+}
+
+/*
+ProbabilisticIDF is log10((N - df + 0.5) / (df + 0.5)) for a word in df of
+the N documents, which is the log odds of a document not containing the word.
+It is negative for words in more than half of the documents.
+*/
+func ProbabilisticIDF(documents, totalDocuments int) (float64) {
+  return math.Log10((float64(totalDocuments - documents) + 0.5) / (float64(documents) + 0.5))
+}
+
+/*
+BM25IDF is the IDF used by BM25, ln(1 + (N - df + 0.5) / (df + 0.5)) for a
+word in df of the N documents. Unlike ProbabilisticIDF, it is never negative.
+*/
+func BM25IDF(documents, totalDocuments int) (float64) {
+  return math.Log(1.0 + (float64(totalDocuments - documents) + 0.5) / (float64(documents) + 0.5))
+}
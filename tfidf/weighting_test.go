@@ -0,0 +1,99 @@
+package tfidf
+
+import (
+  "math"
+  "testing"
+)
+
+func TestTermFrequencyFunctions(t *testing.T) {
+  bm25 := BM25(DefaultBM25K1, DefaultBM25B)
+  fixtures := []struct {
+    Name string
+    TF func(counts TermCounts) (float64)
+    Counts TermCounts
+    ExpectedTF float64
+  }{
+    {"raw", RawTF, TermCounts{Freq: 3, DocMaxWordFreq: 5}, 3.0},
+    {"log", LogTF, TermCounts{Freq: 100, DocMaxWordFreq: 100}, 3.0},
+    {"log of a missing word", LogTF, TermCounts{Freq: 0, DocMaxWordFreq: 100}, 0.0},
+    {"augmented", AugmentedTF, TermCounts{Freq: 15, DocMaxWordFreq: 43}, 0.674418605},
+    {"boolean", BooleanTF, TermCounts{Freq: 2, DocMaxWordFreq: 4}, 1.0},
+    {"boolean of a missing word", BooleanTF, TermCounts{Freq: 0, DocMaxWordFreq: 4}, 0.0},
+    {"bm25 of an average document", bm25.TF, TermCounts{Freq: 2, DocLength: 10, AvgDocLength: 10}, 1.375},
+    {"bm25 of a long document", bm25.TF, TermCounts{Freq: 2, DocLength: 20, AvgDocLength: 10}, 1.073170732},
+    {"bm25 of an empty corpus", bm25.TF, TermCounts{Freq: 2}, 1.375},
+    {"default", WeightingScheme{}.termFrequency, TermCounts{Freq: 15, DocMaxWordFreq: 43}, 0.674418605},
+  }
+
+  for _, f := range fixtures {
+    tf := f.TF(f.Counts)
+    if math.Abs(tf - f.ExpectedTF) > floatEqualThresh {
+      t.Errorf("Received unexpected %s TF value: counts=%+v, result=%v, expected=%v",
+        f.Name, f.Counts, tf, f.ExpectedTF)
+    }
+  }
+}
+
+func TestInverseDocumentFrequencyFunctions(t *testing.T) {
+  fixtures := []struct {
+    Name string
+    IDF func(documents, totalDocuments int) (float64)
+    Documents int
+    TotalDocuments int
+    ExpectedIDF float64
+  }{
+    {"smoothed", SmoothedIDF, 2, 2, -0.176091259},
+    {"smoothed of a missing word", SmoothedIDF, 0, 2, 0.3010299956},
+    {"probabilistic", ProbabilisticIDF, 1, 10, 0.801632346},
+    {"probabilistic of a common word", ProbabilisticIDF, 9, 10, -0.801632346},
+    {"bm25", BM25IDF, 1, 10, 1.992430165},
+    {"bm25 of a word in every document", BM25IDF, 10, 10, 0.046520016},
+    {"default", WeightingScheme{}.inverseDocumentFrequency, 2, 2, -0.176091259},
+  }
+
+  for _, f := range fixtures {
+    idf := f.IDF(f.Documents, f.TotalDocuments)
+    if math.Abs(idf - f.ExpectedIDF) > floatEqualThresh {
+      t.Errorf("Received unexpected %s IDF value: documents=%v, total=%v, result=%v, expected=%v",
+        f.Name, f.Documents, f.TotalDocuments, idf, f.ExpectedIDF)
+    }
+  }
+}
+
+func TestBM25MemoryTFIDF(t *testing.T) {
+  tfidf := NewMemoryTFIDF()
+  tfidf.Weighting = BM25(DefaultBM25K1, DefaultBM25B)
+
+  documents := map[int][]string{
+    1: {"a", "a", "b"},
+    2: {"a", "c", "c", "c", "c", "c"},
+  }
+  for documentId, words := range documents {
+    err := tfidf.StoreDocument(documentId, words)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to store a document: err=%v", err)
+    }
+  }
+
+  fixtures := []struct {
+    Word string
+    DocumentId int
+    ExpectedScore float64
+  }{
+    {"a", 1, 0.276625810},
+    {"a", 2, 0.160442970},
+    {"c", 2, 1.173018306},
+    {"b", 2, 0.0},
+  }
+
+  for _, f := range fixtures {
+    score, err := tfidf.Score(f.Word, f.DocumentId)
+    if err != nil {
+      t.Errorf("Obtained an error while trying to get BM25 score: err=%v", err)
+    }
+    if math.Abs(score - f.ExpectedScore) > floatEqualThresh {
+      t.Errorf("Received unexpected BM25 value: word=%v, document=%v, result=%v, expected=%v",
+        f.Word, f.DocumentId, score, f.ExpectedScore)
+    }
+  }
+}